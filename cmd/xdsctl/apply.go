@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/miekg/xds/pkg/adminpb"
+	"github.com/urfave/cli/v2"
+)
+
+// apply uploads the cluster in the -f file, creating or replacing the cluster.
+func apply(c *cli.Context) error {
+	file := c.String("f")
+	if file == "" || c.Args().Len() != 0 {
+		return ErrArg(c.Args().Slice())
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	pb := &xdspb2.Cluster{}
+	if err := proto.UnmarshalText(string(data), pb); err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	// if the file follows the "cluster.NAME.textpb" convention, check the name as xds does.
+	base := filepath.Base(file)
+	if strings.HasPrefix(base, "cluster.") && strings.HasSuffix(base, ".textpb") {
+		if name := base[8 : len(base)-7]; name != pb.GetName() {
+			return fmt.Errorf("cluster name %q does not match file: %q: %s", pb.GetName(), name, base)
+		}
+	}
+	any, err := ptypes.MarshalAny(pb)
+	if err != nil {
+		return err
+	}
+
+	cl, err := New(c)
+	if err != nil {
+		return err
+	}
+	defer cl.Stop()
+
+	if cl.dry {
+		return nil
+	}
+
+	_, err = adminpb.NewAdminServiceClient(cl.cc).UpsertCluster(c.Context, &adminpb.UpsertClusterRequest{Cluster: any})
+	return err
+}
+
+// deleteCluster deletes a cluster.
+func deleteCluster(c *cli.Context) error {
+	args := c.Args().Slice()
+	if len(args) != 1 {
+		return ErrArg(args)
+	}
+
+	cl, err := New(c)
+	if err != nil {
+		return err
+	}
+	defer cl.Stop()
+
+	if cl.dry {
+		return nil
+	}
+
+	_, err = adminpb.NewAdminServiceClient(cl.cc).DeleteCluster(c.Context, &adminpb.DeleteClusterRequest{Cluster: args[0]})
+	return err
+}
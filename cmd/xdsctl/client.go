@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	structpb "github.com/golang/protobuf/ptypes/struct"
+	xdstls "github.com/miekg/xds/pkg/tls"
 	"github.com/urfave/cli/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // Client talks to the grpc manager's endpoint.
@@ -17,7 +20,8 @@ type Client struct {
 }
 
 // New returns a new client that's dialed to addr using node as the local identifier.
-// if flgClear is set grpc.WithInsecure is added to opts.
+// if flgClear is set grpc.WithInsecure is added to opts, otherwise TLS is used with the certificates given on the
+// command line.
 func New(c *cli.Context, opts ...grpc.DialOption) (*Client, error) {
 	hostname, _ := os.Hostname()
 	node := &corepb2.Node{Id: c.String("n"), Metadata: &structpb.Struct{
@@ -31,6 +35,15 @@ func New(c *cli.Context, opts ...grpc.DialOption) (*Client, error) {
 	}
 	if c.Bool("k") {
 		opts = append(opts, grpc.WithInsecure())
+	} else {
+		cfg, err := xdstls.NewClientConfig(c.String("cert"), c.String("key"), c.String("ca"))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	}
+	if t := c.String("t"); t != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(token{token: t, secure: !c.Bool("k")}))
 	}
 
 	cc, err := grpc.Dial(c.String("s"), opts...)
@@ -40,6 +53,18 @@ func New(c *cli.Context, opts ...grpc.DialOption) (*Client, error) {
 	return &Client{cc: cc, node: node}, nil
 }
 
+// token sends a bearer token with each RPC.
+type token struct {
+	token  string
+	secure bool
+}
+
+func (t token) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t token) RequireTransportSecurity() bool { return t.secure }
+
 func (c *Client) Stop() error {
 	if c.dry {
 		return nil
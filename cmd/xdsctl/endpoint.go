@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/miekg/xds/pkg/adminpb"
+	"github.com/urfave/cli/v2"
+)
+
+// endpointAdd adds an endpoint to a cluster.
+func endpointAdd(c *cli.Context) error {
+	args := c.Args().Slice()
+	if len(args) != 2 {
+		return ErrArg(args)
+	}
+
+	cl, err := New(c)
+	if err != nil {
+		return err
+	}
+	defer cl.Stop()
+
+	if cl.dry {
+		return nil
+	}
+
+	req := &adminpb.AddEndpointRequest{
+		Cluster:  args[0],
+		Endpoint: args[1],
+		Locality: c.String("locality"),
+		Weight:   uint32(c.Uint("weight")),
+	}
+	_, err = adminpb.NewAdminServiceClient(cl.cc).AddEndpoint(c.Context, req)
+	return err
+}
+
+// endpointRemove removes an endpoint from a cluster.
+func endpointRemove(c *cli.Context) error {
+	args := c.Args().Slice()
+	if len(args) != 2 {
+		return ErrArg(args)
+	}
+
+	cl, err := New(c)
+	if err != nil {
+		return err
+	}
+	defer cl.Stop()
+
+	if cl.dry {
+		return nil
+	}
+
+	req := &adminpb.RemoveEndpointRequest{Cluster: args[0], Endpoint: args[1]}
+	_, err = adminpb.NewAdminServiceClient(cl.cc).RemoveEndpoint(c.Context, req)
+	return err
+}
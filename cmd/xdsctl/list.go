@@ -12,6 +12,7 @@ import (
 	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/golang/protobuf/ptypes"
+	"github.com/miekg/xds/pkg/adminpb"
 	"github.com/miekg/xds/pkg/cache"
 	"github.com/urfave/cli/v2"
 )
@@ -31,22 +32,18 @@ func list(c *cli.Context) error {
 		return listEndpoints(c)
 	}
 
-	dr := &xdspb2.DiscoveryRequest{Node: cl.node}
-	cds := xdspb2.NewClusterDiscoveryServiceClient(cl.cc)
-	resp, err := cds.FetchClusters(c.Context, dr)
+	resp, err := adminpb.NewAdminServiceClient(cl.cc).ListClusters(c.Context, &adminpb.ListClustersRequest{})
 	if err != nil {
 		return err
 	}
 
 	clusters := []*xdspb2.Cluster{}
-	for _, r := range resp.GetResources() {
-		var any ptypes.DynamicAny
-		if err := ptypes.UnmarshalAny(r, &any); err != nil {
+	for _, r := range resp.GetClusters() {
+		cl := &xdspb2.Cluster{}
+		if err := ptypes.UnmarshalAny(r, cl); err != nil {
 			return err
 		}
-		if c, ok := any.Message.(*xdspb2.Cluster); ok { // v2
-			clusters = append(clusters, c)
-		}
+		clusters = append(clusters, cl)
 	}
 	if len(clusters) == 0 {
 		return fmt.Errorf("no clusters found")
@@ -76,7 +73,7 @@ func list(c *cli.Context) error {
 			hcname = append(hcname, name)
 
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t\n", u.GetName(), resp.GetVersionInfo(), strings.Join(hcname, Joiner))
+		fmt.Fprintf(w, "%s\t%d\t%s\t\n", u.GetName(), resp.GetVersion(), strings.Join(hcname, Joiner))
 	}
 
 	return nil
@@ -102,60 +99,15 @@ func listEndpoints(c *cli.Context) error {
 		cluster = args[0]
 	}
 
-	// We can't use resource names here, because the API then assumes we care about
-	// these and it will have a watch for it; if we then ask again we don't get any replies if
-	// there isn't any updates to the clusters. So keep ResourceNames empty and we filter
-	// down below.
-	dr := &xdspb2.DiscoveryRequest{Node: cl.node}
-	eds := xdspb2.NewEndpointDiscoveryServiceClient(cl.cc)
-	resp, err := eds.FetchEndpoints(c.Context, dr)
+	resp, err := adminpb.NewAdminServiceClient(cl.cc).GetCluster(c.Context, &adminpb.GetClusterRequest{Cluster: cluster})
 	if err != nil {
 		return err
 	}
-
-	endpoints := []*xdspb2.ClusterLoadAssignment{}
-	for _, r := range resp.GetResources() {
-		var any ptypes.DynamicAny
-		if err := ptypes.UnmarshalAny(r, &any); err != nil {
-			return err
-		}
-		if c, ok := any.Message.(*xdspb2.ClusterLoadAssignment); ok {
-			if cluster != "" && cluster != c.ClusterName {
-				continue
-			}
-			endpoints = append(endpoints, c)
-
-		}
-	}
-	if len(endpoints) == 0 {
-		return fmt.Errorf("no endpoints found")
-	}
-
-	// fetch cluster as well for load reporting stats.
-	cds := xdspb2.NewClusterDiscoveryServiceClient(cl.cc)
-	resp, err = cds.FetchClusters(c.Context, dr)
-	if err != nil {
+	clu := &xdspb2.Cluster{}
+	if err := ptypes.UnmarshalAny(resp.GetCluster(), clu); err != nil {
 		return err
 	}
-
-	var clu *xdspb2.Cluster
-	for _, r := range resp.GetResources() {
-		var any ptypes.DynamicAny
-		if err := ptypes.UnmarshalAny(r, &any); err != nil {
-			return err
-		}
-		if c, ok := any.Message.(*xdspb2.Cluster); ok { // v2
-			if c.Name == cluster {
-				clu = c
-				break
-			}
-		}
-	}
-	if clu == nil {
-		return fmt.Errorf("no clusters found")
-	}
-
-	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].ClusterName < endpoints[j].ClusterName })
+	endpoints := []*xdspb2.ClusterLoadAssignment{clu.GetLoadAssignment()}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
 	defer w.Flush()
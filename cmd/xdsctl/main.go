@@ -16,6 +16,10 @@ func main() {
 			&cli.StringFlag{Name: "s", Usage: "server `ADDRESS` to connect to", Required: true},
 			&cli.StringFlag{Name: "n", Usage: "node `ID` to use", Value: "test-id"},
 			&cli.BoolFlag{Name: "k", Usage: "disable TLS"},
+			&cli.StringFlag{Name: "cert", Usage: "client certificate `FILE` for mTLS"},
+			&cli.StringFlag{Name: "key", Usage: "client key `FILE` for mTLS"},
+			&cli.StringFlag{Name: "ca", Usage: "CA bundle `FILE` to verify the server with, defaults to the system's CAs"},
+			&cli.StringFlag{Name: "t", Usage: "bearer `TOKEN` to authenticate with", EnvVars: []string{"XDSCTL_TOKEN"}},
 			&cli.BoolFlag{Name: "H", Usage: "print header in output", Value: true},
 			&cli.BoolFlag{Name: "N", Usage: "dry run"},
 			&cli.BoolFlag{Name: "d", Usage: "dump protocol buffers to standard output"},
@@ -32,21 +36,27 @@ func main() {
 				Action:    list,
 			},
 			{
-				Name: "drain",
-				Description: "Drain sets the endpoint's health to DRAINING. If no endpoint is given all endpoints for this cluster will be set.\n" +
-					"   When clusters share endpoints they will get updated as well.",
-				Category:  "health",
-				Usage:     "set health status to DRAINING for endpoints or entire clusters",
-				ArgsUsage: "CLUSTER [ENDPOINT]",
+				Name: "status",
+				Description: "Status shows, for each connected node, the status of the listeners, clusters and routes sent to it\n" +
+					"   (CSDS). VERSION is the version the node acknowledged. If a node ID is given only that node is shown.",
+				Usage:     "show the configuration status of connected nodes",
+				ArgsUsage: "[NODE]",
+				Action:    status,
+			},
+			{
+				Name:        "drain",
+				Description: "Drain sets the endpoint's health to DRAINING. If no endpoint is given all endpoints for this cluster will be set.",
+				Category:    "health",
+				Usage:       "set health status to DRAINING for endpoints or entire clusters",
+				ArgsUsage:   "CLUSTER [ENDPOINT]",
 				Action: func(c *cli.Context) error {
 					err := healthStatus(c, "DRAINING")
 					return err
 				},
 			},
 			{
-				Name: "undrain",
-				Description: "Undrain sets the endpoint's health to UNKNOWN. If no endpoint is given all endpoints for this cluster will be set.\n" +
-					"   When clusters share endpoints they will get updated as well.",
+				Name:        "undrain",
+				Description: "Undrain sets the endpoint's health to UNKNOWN. If no endpoint is given all endpoints for this cluster will be set.",
 				Flags: []cli.Flag{
 					&cli.BoolFlag{Name: "i", Usage: "set endpoint immediately to HEALTHY"},
 				},
@@ -65,13 +75,64 @@ func main() {
 			{
 				Name: "health",
 				Description: "Health sets the health for endpoints in a cluster. If no endpoint is given all endpoints for this cluster will be set.\n" +
-					"   The mandatory argument HEALTH_STATUS can be: 'UNKNOWN', 'HEALTHY', 'UNHEALTHY', 'DRAINING', 'TIMEOUT' or 'DEGRADED'.\n" +
-					"   When clusters share endpoint they will get updated as well.",
+					"   The mandatory argument HEALTH_STATUS can be: 'UNKNOWN', 'HEALTHY', 'UNHEALTHY', 'DRAINING', 'TIMEOUT' or 'DEGRADED'.",
 				Category:  "health",
 				ArgsUsage: "CLUSTER [ENDPOINT] HEALTH_STATUS",
 				Usage:     "set health status for endpoints or entire clusters",
 				Action:    health,
 			},
+			{
+				Name:        "endpoint",
+				Description: "Add or remove endpoints of a cluster.",
+				Usage:       "add or remove endpoints",
+				Subcommands: []*cli.Command{
+					{
+						Name: "add",
+						Description: "Add adds the endpoint (ADDRESS:PORT) to the cluster. The endpoint is added to the given locality,\n" +
+							"   which is created if it doesn't exist yet.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "locality", Usage: "`LOCALITY` (REGION/ZONE/SUBZONE) of the endpoint"},
+							&cli.UintFlag{Name: "weight", Usage: "load balancing `WEIGHT` of the endpoint"},
+						},
+						Usage:     "add an endpoint to a cluster",
+						ArgsUsage: "CLUSTER ENDPOINT",
+						Action:    endpointAdd,
+					},
+					{
+						Name:        "remove",
+						Aliases:     []string{"rm"},
+						Description: "Remove removes the endpoint (ADDRESS:PORT) from the cluster.",
+						Usage:       "remove an endpoint from a cluster",
+						ArgsUsage:   "CLUSTER ENDPOINT",
+						Action:      endpointRemove,
+					},
+				},
+			},
+			{
+				Name: "apply",
+				Description: "Apply uploads the cluster in FILE (in text protobuf format) to the server, replacing the cluster if it\n" +
+					"   already exists. The server validates the cluster as if it was read from its configuration directory.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "f", Usage: "`FILE` with the cluster", Required: true},
+				},
+				Usage:     "create or replace a cluster",
+				ArgsUsage: " ",
+				Action:    apply,
+			},
+			{
+				Name:        "delete",
+				Description: "Delete deletes resources from the server.",
+				Usage:       "delete resources",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "cluster",
+						Description: "Cluster deletes the cluster.",
+						Usage:       "delete a cluster",
+						ArgsUsage:   "CLUSTER",
+						Action:      deleteCluster,
+					},
+				},
+			},
 			{
 				Name:        "load",
 				Description: "Report load for a cluster's endpoint.",
@@ -80,11 +141,15 @@ func main() {
 				Action:      load,
 			},
 			{
-				Name:        "weight",
-				Description: "Set endpoint's weight within a cluster..",
-				Usage:       "set endpoint's weight within a cluster",
-				ArgsUsage:   "CLUSTER ENDPOINT WEIGHT",
-				Action:      weight,
+				Name: "weight",
+				Description: "Set endpoint's weight within a cluster. With -l the weight of a locality (REGION/ZONE/SUBZONE) is set\n" +
+					"   instead.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "l", Usage: "set the weight of a locality"},
+				},
+				Usage:     "set endpoint's or locality's weight within a cluster",
+				ArgsUsage: "CLUSTER ENDPOINT|LOCALITY WEIGHT",
+				Action:    weight,
 			},
 		},
 	}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	statuspb2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	matcherpb2 "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
+	"github.com/urfave/cli/v2"
+)
+
+// status shows the status of the configuration of the connected nodes (CSDS).
+func status(c *cli.Context) error {
+	args := c.Args().Slice()
+	if len(args) > 1 {
+		return ErrArg(args)
+	}
+
+	cl, err := New(c)
+	if err != nil {
+		return err
+	}
+	defer cl.Stop()
+
+	if cl.dry {
+		return nil
+	}
+
+	req := &statuspb2.ClientStatusRequest{}
+	if len(args) == 1 {
+		req.NodeMatchers = []*matcherpb2.NodeMatcher{{
+			NodeId: &matcherpb2.StringMatcher{MatchPattern: &matcherpb2.StringMatcher_Exact{Exact: args[0]}},
+		}}
+	}
+	resp, err := statuspb2.NewClientStatusDiscoveryServiceClient(cl.cc).FetchClientStatus(c.Context, req)
+	if err != nil {
+		return err
+	}
+	if len(resp.GetConfig()) == 0 {
+		return fmt.Errorf("no nodes found")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+	defer w.Flush()
+	if c.Bool("H") {
+		fmt.Fprintln(w, "NODE\tCLUSTER\tTYPE\tSTATUS\tVERSION\tRESOURCES\t")
+	}
+	for _, cc := range resp.GetConfig() {
+		for _, x := range cc.GetXdsConfig() {
+			typ, version, n := "", "", 0
+			switch {
+			case x.GetListenerConfig() != nil:
+				typ, version, n = "LDS", x.GetListenerConfig().GetVersionInfo(), len(x.GetListenerConfig().GetDynamicListeners())
+			case x.GetClusterConfig() != nil:
+				typ, version, n = "CDS", x.GetClusterConfig().GetVersionInfo(), len(x.GetClusterConfig().GetDynamicActiveClusters())
+			case x.GetRouteConfig() != nil:
+				typ, n = "RDS", len(x.GetRouteConfig().GetDynamicRouteConfigs())
+			default:
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t\n", cc.GetNode().GetId(), cc.GetNode().GetCluster(), typ, x.GetStatus(), version, n)
+		}
+	}
+	return nil
+}
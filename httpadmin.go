@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/miekg/xds/pkg/audit"
+	"github.com/miekg/xds/pkg/cache"
+	"github.com/miekg/xds/pkg/log"
+	"github.com/miekg/xds/pkg/server"
+)
+
+// reloadResult is the result of a configuration reload.
+type reloadResult struct {
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Report  []string  `json:"report,omitempty"` // what changed, or why files were rejected.
+}
+
+var lastReload struct {
+	sync.Mutex
+	r *reloadResult
+}
+
+// setReload records the result of the last reload.
+func setReload(success bool, report []string) {
+	lastReload.Lock()
+	defer lastReload.Unlock()
+	lastReload.r = &reloadResult{Time: time.Now(), Success: success, Report: report}
+}
+
+// registerAdmin registers the handlers of the HTTP admin interface on mux:
+//
+//	/config_dump - the clusters and views in the cache and its version.
+//	/clients     - the connected streams with the versions they have sent and ACKed per type URL.
+//	/reload      - the result of the last configuration reload.
+//	/audit       - the audit log, filtered on the "cluster", "endpoint", "identity", "operation", "since" and
+//	               "until" (RFC 3339) parameters; "limit" returns only the last entries.
+//	/logging     - the log levels per component, a POST with "component" and "level" parameters changes them.
+//	               If policy is not nil, the caller must be allowed the logging action.
+func registerAdmin(mux *http.ServeMux, config *cache.Cluster, srv server.Server, policy *server.Policy) {
+	mux.HandleFunc("/config_dump", func(w http.ResponseWriter, r *http.Request) {
+		m := jsonpb.Marshaler{OrigName: true}
+		dump := struct {
+			Version  string                 `json:"version"`
+			Clusters []json.RawMessage      `json:"clusters"`
+			Views    map[string]*cache.View `json:"views,omitempty"`
+		}{Version: strconv.FormatUint(config.Version(), 10), Clusters: []json.RawMessage{}, Views: map[string]*cache.View{}}
+		for _, v := range config.Views() {
+			dump.Views[v.Name] = v
+		}
+		for _, name := range config.All() {
+			cl, _ := config.Retrieve(name)
+			if cl == nil {
+				continue
+			}
+			s, err := m.MarshalToString(cl)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			dump.Clusters = append(dump.Clusters, json.RawMessage(s))
+		}
+		writeJSON(w, dump)
+	})
+	mux.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, srv.Clients())
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		lastReload.Lock()
+		defer lastReload.Unlock()
+		writeJSON(w, lastReload.r)
+	})
+	mux.HandleFunc("/audit", func(w http.ResponseWriter, r *http.Request) {
+		if auditLog == nil {
+			http.Error(w, "audit log not enabled", http.StatusNotFound)
+			return
+		}
+		q, err := auditQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := auditLog.Query(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, entries)
+	})
+	mux.HandleFunc("/logging", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			if policy != nil {
+				if id := policy.HTTPIdentity(r); !policy.Allowed(id, server.ActionLogging, "") {
+					log.Warningf("Denied %q for %q", server.ActionLogging, id)
+					http.Error(w, "not allowed to change the log levels", http.StatusForbidden)
+					return
+				}
+			}
+			component := r.FormValue("component")
+			if component == "default" {
+				component = ""
+			}
+			if err := log.SetLevel(component, r.FormValue("level")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Infof("Log level of %q set to %q", r.FormValue("component"), r.FormValue("level"))
+		}
+		levels := log.Levels()
+		levels["default"] = levels[""]
+		delete(levels, "")
+		writeJSON(w, levels)
+	})
+}
+
+// auditQuery returns the audit log query from the parameters in r.
+func auditQuery(r *http.Request) (audit.Query, error) {
+	q := audit.Query{
+		Cluster:   r.FormValue("cluster"),
+		Endpoint:  r.FormValue("endpoint"),
+		Identity:  r.FormValue("identity"),
+		Operation: r.FormValue("operation"),
+	}
+	var err error
+	if v := r.FormValue("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, err
+		}
+	}
+	if v := r.FormValue("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, err
+		}
+	}
+	if v := r.FormValue("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(append(buf, '\n')); err != nil {
+		log.Debugf("Failed to write admin response: %s", err)
+	}
+}
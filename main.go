@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"net/http"
 	"os"
 	"os/signal"
-	"sort"
+	"syscall"
 	"time"
 
+	"github.com/miekg/xds/pkg/audit"
 	"github.com/miekg/xds/pkg/cache"
+	"github.com/miekg/xds/pkg/healthcheck"
 	"github.com/miekg/xds/pkg/log"
+	"github.com/miekg/xds/pkg/metrics"
 	"github.com/miekg/xds/pkg/server"
+	"github.com/miekg/xds/pkg/store"
+	xdstls "github.com/miekg/xds/pkg/tls"
 )
 
 var (
@@ -18,6 +25,18 @@ var (
 	addr   = flag.String("addr", ":18000", "management server address")
 	conf   = flag.String("conf", ".", "cluster configuration directory")
 	debug  = flag.Bool("debug", false, "enable debug logging")
+	level  = flag.String("loglevel", "", "log levels, either LEVEL or COMPONENT=LEVEL,COMPONENT=LEVEL,...")
+	ljson  = flag.Bool("logjson", false, "log JSON objects instead of lines of text")
+	hc     = flag.Bool("healthcheck", false, "run the clusters' health checks against the endpoints")
+	cert   = flag.String("cert", "", "TLS certificate file, enables TLS")
+	key    = flag.String("key", "", "TLS key file")
+	ca     = flag.String("ca", "", "CA bundle to verify client certificates with, enables mTLS")
+	pol    = flag.String("policy", "", "authorization policy file, if not given everyone may change the cache")
+	state  = flag.String("state", "", "file to keep the state of the cache in, so it survives restarts")
+	mon    = flag.String("metrics", "", "address to serve Prometheus metrics on (/metrics), disabled if empty")
+	adm    = flag.String("admin", "", "address to serve the HTTP admin interface on, disabled if empty")
+	aud    = flag.String("audit", "", "file to append an audit log of all changes to the cache to")
+	wb     = flag.Bool("writeback", false, "write clusters changed at runtime back to the configuration directory")
 )
 
 // main returns code 1 if any of the batches failed to pass all requests
@@ -26,6 +45,10 @@ func main() {
 	if *debug {
 		log.D.Set()
 	}
+	if err := log.ParseLevels(*level); err != nil {
+		log.Fatal(err)
+	}
+	log.SetJSON(*ljson)
 	clusters, err := parseClusters(*conf)
 	if err != nil {
 		log.Fatal(err)
@@ -35,23 +58,108 @@ func main() {
 	for _, cl := range clusters {
 		config.Insert(cl)
 	}
-	log.Infof("Initialized cache with 'v1' of %d clusters parsed from directory: %q", len(clusters), *conf)
+	views, err := parseViews(*conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.SetViews(views)
+	if *state != "" {
+		st, err := store.Open(*state)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := config.SetStore(st); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *aud != "" {
+		if auditLog, err = audit.Open(*aud); err != nil {
+			log.Fatal(err)
+		}
+	}
+	setReload(true, nil)
+	log.Infof("Initialized cache with version %d of %d clusters and %d views parsed from directory: %q", config.Version(), len(clusters), len(views), *conf)
 
-	// Every 10s look through the config directory to see if there are new files to be loaded
+	// Watch the config directory for changes, if that isn't possible, or stops working, look through it every
+	// 10s. As the watch can miss changes we also look through it every minute.
 	stop := make(chan bool)
-	go rereadConfig(config, *conf, stop)
+	go func() {
+		if err := watchConfig(config, *conf, stop); err != nil {
+			log.Warningf("Not watching %q, rereading it every 10s: %s", *conf, err)
+			rereadConfig(config, *conf, 10*time.Second, stop)
+		}
+	}()
+	go rereadConfig(config, *conf, time.Minute, stop)
+	if *wb {
+		go writeBack(config, *conf, stop)
+	} else {
+		go trackDeletes(config, *conf, stop)
+	}
+
+	var tlsConfig, httpTLSConfig *tls.Config
+	if *cert == "" && (*key != "" || *ca != "") {
+		log.Fatal("-key and -ca need -cert")
+	}
+	if *cert != "" {
+		r, err := xdstls.NewReloader(*cert, *key, *ca)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig = r.ServerConfig()
+		httpTLSConfig = r.HTTPServerConfig()
+	}
+
+	var policy *server.Policy
+	if *pol != "" {
+		if policy, err = server.LoadPolicy(*pol); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
-	srv := server.NewServer(ctx, config)
-	go RunManagementServer(ctx, srv, *addr) // start the xDS server
+	srv := server.NewServer(ctx, config, policy, auditLog)
+
+	// metrics and the admin interface may share a listener.
+	muxes := map[string]*http.ServeMux{}
+	mux := func(addr string) *http.ServeMux {
+		if _, ok := muxes[addr]; !ok {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+	if *mon != "" {
+		mux(*mon).Handle("/metrics", metrics.Handler())
+	}
+	if *adm != "" {
+		registerAdmin(mux(*adm), config, srv, policy)
+	}
+	// these use TLS (and client certificates) in the same way as the xDS server.
+	for a, m := range muxes {
+		go func(a string, m *http.ServeMux) {
+			hs := &http.Server{Addr: a, Handler: m, TLSConfig: httpTLSConfig}
+			if httpTLSConfig == nil {
+				log.Fatal(hs.ListenAndServe())
+			}
+			log.Fatal(hs.ListenAndServeTLS("", ""))
+		}(a, m)
+	}
+	go RunManagementServer(ctx, srv, *addr, tlsConfig) // start the xDS server
+	if *hc {
+		go healthcheck.New(config, auditLog).Run(ctx) // start health checking the endpoints
+	}
 
-	sig := make(chan os.Signal)
-	signal.Notify(sig, os.Interrupt)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGHUP)
 
 	for {
 		select {
-		case <-sig:
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				log.Infof("Received SIGHUP, reloading %q", *conf)
+				reloadConfig(config, *conf, false)
+				continue
+			}
 			close(stop)
 			cancel()
 			os.Exit(1)
@@ -59,8 +167,10 @@ func main() {
 	}
 }
 
-func rereadConfig(config *cache.Cluster, path string, stop <-chan bool) {
-	tick := time.NewTicker(10 * time.Second)
+// rereadConfig rereads the entire configuration directory every interval. This catches the changes watchConfig
+// missed, or is all we do when the directory can't be watched.
+func rereadConfig(config *cache.Cluster, path string, interval time.Duration, stop <-chan bool) {
+	tick := time.NewTicker(interval)
 	defer tick.Stop()
 
 	for {
@@ -68,29 +178,7 @@ func rereadConfig(config *cache.Cluster, path string, stop <-chan bool) {
 		case <-stop:
 			return
 		case <-tick.C:
-			clusters, err := parseClusters(path)
-			if err != nil {
-				log.Warningf("Error reparsing clusters: %s", err)
-				continue
-			}
-			current := config.All()
-			for _, c := range clusters {
-				i := sort.Search(len(current), func(i int) bool { return c.Name <= current[i] })
-				if i < len(current) && current[i] == c.Name {
-					cl, _ := config.Retrieve(current[i])
-					h1 := cache.HashFromMetadata(cl)
-					h2 := cache.HashFromMetadata(c)
-					if h1 != h2 {
-						log.Infof("cluster in %q updated, re-inserting cluster %q", path, c.Name)
-						config.Insert(c)
-
-					}
-					continue
-				}
-				// new cluster
-				log.Infof("Found new cluster in %q, adding cluster %q", path, c.Name)
-				config.Insert(c)
-			}
+			reloadConfig(config, path, true)
 		}
 	}
 }
@@ -2,16 +2,14 @@ package main
 
 import (
 	"crypto/sha1"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"strings"
-	"time"
 
 	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
-	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/golang/protobuf/proto"
-	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/miekg/xds/pkg/cache"
 )
 
@@ -25,67 +23,106 @@ func parseClusters(path string) ([]*xdspb2.Cluster, error) {
 		if f.IsDir() {
 			continue
 		}
-		if filepath.Ext(f.Name()) != ".textpb" {
+		name, ok := clusterName(f.Name())
+		if !ok {
 			continue
 		}
-		if !strings.HasPrefix(f.Name(), "cluster.") {
-			continue
-		}
-		data, err := ioutil.ReadFile(filepath.Join(path, f.Name()))
+		pb, err := parseCluster(path, name)
 		if err != nil {
 			return nil, err
 		}
+		cls = append(cls, pb)
+	}
+	return cls, nil
+}
 
-		// suffix and prefix check, now the middle is the cluster name
-		name := f.Name()[8 : len(f.Name())-7]
+// clusterName returns the cluster name from a "cluster.NAME.textpb" file name. If file doesn't follow that
+// pattern false is returned.
+func clusterName(file string) (string, bool) {
+	if filepath.Ext(file) != ".textpb" || !strings.HasPrefix(file, "cluster.") {
+		return "", false
+	}
+	if len(file) <= 15 {
+		return "", false
+	}
+	// suffix and prefix check, now the middle is the cluster name
+	return file[8 : len(file)-7], true
+}
 
-		pb := &xdspb2.Cluster{}
-		if err := proto.UnmarshalText(string(data), pb); err != nil {
-			return nil, fmt.Errorf("cluster %q: %s", name, err)
-		}
-		if name != pb.GetName() {
-			return nil, fmt.Errorf("cluster name %q does not match file: %q: %s", pb.GetName(), name, f.Name())
-		}
-		// some sanity checks
-		if pb.GetType() != xdspb2.Cluster_EDS {
-			return nil, fmt.Errorf("cluster %q must have discovery type set to EDS", name)
-		}
-		hcs := pb.GetHealthChecks()
-		if len(hcs) == 0 {
-			return nil, fmt.Errorf("cluster %q must have health checks", name)
-		}
-		for _, hc := range hcs {
-			setDurationIfNil(hc.Timeout, 5*time.Second, fmt.Sprintf("Cluster %q, setting %s to", name, "Timeout"))
-			setDurationIfNil(hc.Interval, 10*time.Second, fmt.Sprintf("Cluster %q, setting %s to", name, "Internval"))
-			setDurationIfNil(hc.InitialJitter, 2*time.Second, fmt.Sprintf("Cluster %q, setting %s to", name, "InitialJitter"))
-			setDurationIfNil(hc.IntervalJitter, 1*time.Second, fmt.Sprintf("Cluster %q, setting %s to", name, "IntervalJitter"))
-		}
-		pb.EdsClusterConfig = &xdspb2.Cluster_EdsClusterConfig{
-			EdsConfig: &corepb2.ConfigSource{ConfigSourceSpecifier: &corepb2.ConfigSource_Ads{Ads: &corepb2.AggregatedConfigSource{}}},
-		}
+// parseCluster parses the file of cluster name in path.
+func parseCluster(path, name string) (*xdspb2.Cluster, error) {
+	file := "cluster." + name + ".textpb"
+	data, err := ioutil.ReadFile(filepath.Join(path, file))
+	if err != nil {
+		return nil, err
+	}
 
-		// Now we're fixing up clusters, by setting some missing value and defaulting settings (mostly durations) that may be left out.
-		endpoints := pb.GetLoadAssignment()
+	pb := &xdspb2.Cluster{}
+	if err := proto.UnmarshalText(string(data), pb); err != nil {
+		return nil, fmt.Errorf("cluster %q: %s", name, err)
+	}
+	if name != pb.GetName() {
+		return nil, fmt.Errorf("cluster name %q does not match file: %q: %s", pb.GetName(), name, file)
+	}
+	if err := cache.Validate(pb); err != nil {
+		return nil, err
+	}
 
-		// If the endpoints cluster name if not set, set it to the cluster name
-		if endpoints.ClusterName != pb.GetName() {
-			endpoints.ClusterName = pb.GetName()
-		}
+	// hash the file and set in the metadata.
+	h := sha1.New()
+	h.Write(data)
+	bs := h.Sum(nil)
+	cache.SetHashInMetadata(pb, fmt.Sprintf("%x", bs))
+	return pb, nil
+}
 
-		// hash the file and set in the metadata.
-		h := sha1.New()
-		h.Write(data)
-		bs := h.Sum(nil)
-		cache.SetHashInMetadata(pb, fmt.Sprintf("%x", bs))
+// parseViews parses the "view.NAME.json" files in path.
+func parseViews(path string) ([]*cache.View, error) {
+	dir, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	views := []*cache.View{}
+	for _, f := range dir {
+		if f.IsDir() {
+			continue
+		}
+		name, ok := viewName(f.Name())
+		if !ok {
+			continue
+		}
+		v, err := parseView(path, name)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, v)
+	}
+	return views, nil
+}
 
-		cls = append(cls, pb)
+// viewName returns the view name from a "view.NAME.json" file name. If file doesn't follow that pattern false
+// is returned.
+func viewName(file string) (string, bool) {
+	if filepath.Ext(file) != ".json" || !strings.HasPrefix(file, "view.") {
+		return "", false
 	}
-	return cls, nil
+	if len(file) <= 10 {
+		return "", false
+	}
+	// suffix and prefix check, now the middle is the view name
+	return file[5 : len(file)-5], true
 }
 
-func setDurationIfNil(a *duration.Duration, v time.Duration, msg string) {
-	if a != nil {
-		return
+// parseView parses the file of view name in path.
+func parseView(path, name string) (*cache.View, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, "view."+name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	v := &cache.View{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("view %q: %s", name, err)
 	}
-	a = &duration.Duration{Seconds: int64(v / time.Second)} // skip Nanos
+	v.Name = name
+	return v, nil
 }
@@ -0,0 +1,286 @@
+// Package audit implements an append-only audit log of the changes made to the clusters in the cache. Each
+// change is written as a JSON object on a line of its own, recording who made the change, what changed and the
+// old and new value.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/xds/pkg/cache"
+	clog "github.com/miekg/xds/pkg/log"
+)
+
+var log = clog.New(clog.Audit)
+
+// Operations recorded in the audit log.
+const (
+	CreateCluster     = "create_cluster"
+	UpdateCluster     = "update_cluster" // the cluster itself changed, the values are the hashes of its file (if any).
+	DeleteCluster     = "delete_cluster"
+	AddEndpoint       = "add_endpoint"    // the new value is the endpoint's locality.
+	RemoveEndpoint    = "remove_endpoint" // the old value is the endpoint's locality.
+	SetHealth         = "set_health"
+	SetWeight         = "set_weight"
+	SetLocalityWeight = "set_locality_weight"
+)
+
+// Caller identifies who made a change.
+type Caller struct {
+	Identity string `json:"identity,omitempty"` // identity from the client certificate or bearer token, or what made the change, i.e. "config".
+	Peer     string `json:"peer,omitempty"`     // address of the caller.
+	Node     string `json:"node,omitempty"`     // node ID, for changes reported by health checkers (HDS).
+}
+
+// Entry is a single change in the audit log.
+type Entry struct {
+	Time time.Time `json:"time"`
+	Caller
+	Operation string `json:"operation"`
+	Cluster   string `json:"cluster"`
+	Endpoint  string `json:"endpoint,omitempty"` // "address:port"
+	Locality  string `json:"locality,omitempty"` // "region/zone/subzone"
+	Old       string `json:"old,omitempty"`
+	New       string `json:"new,omitempty"`
+}
+
+// Log is an audit log kept in a file.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// Open opens the audit log in path, new entries are appended to the file.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, f: f}, nil
+}
+
+// Close closes the audit log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Record appends entries, made by caller, to the audit log. Entries without a time get the current time. Record
+// on a nil Log does nothing.
+func (l *Log) Record(caller Caller, entries ...Entry) {
+	if l == nil || len(entries) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	buf := []byte{}
+	for _, e := range entries {
+		e.Caller = caller
+		if e.Time.IsZero() {
+			e.Time = now
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Warningf("Failed to marshal audit entry: %s", err)
+			continue
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(buf); err != nil {
+		log.Errorf("Failed to write audit log %q: %s", l.path, err)
+		return
+	}
+	if err := l.f.Sync(); err != nil {
+		log.Errorf("Failed to sync audit log %q: %s", l.path, err)
+	}
+}
+
+// Changes collects the changes made to clusters. Its Observe method is handed to the cache, which calls it for
+// every cluster it changes while it holds its lock, so only the changes made by that call are collected. They
+// are written to the audit log with Record.
+type Changes []Entry
+
+// Observe adds the differences between old and new, see Diff. It is a cache.Observer.
+func (c *Changes) Observe(old, new *xdspb2.Cluster) {
+	*c = append(*c, Diff(old, new)...)
+}
+
+// Query selects entries from the audit log. Empty fields match everything.
+type Query struct {
+	Since     time.Time
+	Until     time.Time
+	Identity  string
+	Operation string
+	Cluster   string
+	Endpoint  string
+	Limit     int // only return the last Limit entries, if not zero.
+}
+
+// Match returns true if e matches q.
+func (q Query) Match(e Entry) bool {
+	switch {
+	case !q.Since.IsZero() && e.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && e.Time.After(q.Until):
+		return false
+	case q.Identity != "" && q.Identity != e.Identity:
+		return false
+	case q.Operation != "" && q.Operation != e.Operation:
+		return false
+	case q.Cluster != "" && q.Cluster != e.Cluster:
+		return false
+	case q.Endpoint != "" && q.Endpoint != e.Endpoint:
+		return false
+	}
+	return true
+}
+
+// Query returns the entries that match q, oldest first. Lines that can't be parsed are skipped.
+func (l *Log) Query(q Query) ([]Entry, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !q.Match(e) {
+			continue
+		}
+		entries = append(entries, e)
+		if q.Limit > 0 && len(entries) > 2*q.Limit {
+			entries = append(entries[:0], entries[len(entries)-q.Limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+// Diff returns the entries that describe the change from old to new. Either may be nil, for a cluster that is
+// created or deleted. Changes to the load and the hash in the metadata are not reported on their own.
+func Diff(old, new *xdspb2.Cluster) []Entry {
+	switch {
+	case old == nil && new == nil:
+		return nil
+	case old == nil:
+		return []Entry{{Operation: CreateCluster, Cluster: new.GetName(), New: cache.HashFromMetadata(new)}}
+	case new == nil:
+		return []Entry{{Operation: DeleteCluster, Cluster: old.GetName(), Old: cache.HashFromMetadata(old)}}
+	}
+
+	name := new.GetName()
+	entries := []Entry{}
+	a, b := cache.Stripped(old), cache.Stripped(new)
+	a.LoadAssignment, b.LoadAssignment = nil, nil
+	if !proto.Equal(a, b) {
+		entries = append(entries, Entry{Operation: UpdateCluster, Cluster: name, Old: cache.HashFromMetadata(old), New: cache.HashFromMetadata(new)})
+	}
+
+	oldEps, oldLocs := endpoints(old)
+	newEps, newLocs := endpoints(new)
+	for _, loc := range union(localityKeys(oldLocs), localityKeys(newLocs)) {
+		o, ook := oldLocs[loc]
+		n, nok := newLocs[loc]
+		if ook && nok && o != n {
+			entries = append(entries, Entry{Operation: SetLocalityWeight, Cluster: name, Locality: loc, Old: o, New: n})
+		}
+	}
+	for _, addr := range union(endpointKeys(oldEps), endpointKeys(newEps)) {
+		o, ook := oldEps[addr]
+		n, nok := newEps[addr]
+		switch {
+		case !ook:
+			entries = append(entries, Entry{Operation: AddEndpoint, Cluster: name, Endpoint: addr, Locality: n.locality, New: n.locality})
+		case !nok:
+			entries = append(entries, Entry{Operation: RemoveEndpoint, Cluster: name, Endpoint: addr, Locality: o.locality, Old: o.locality})
+		default:
+			if o.health != n.health {
+				entries = append(entries, Entry{Operation: SetHealth, Cluster: name, Endpoint: addr, Locality: n.locality, Old: o.health, New: n.health})
+			}
+			if o.weight != n.weight {
+				entries = append(entries, Entry{Operation: SetWeight, Cluster: name, Endpoint: addr, Locality: n.locality, Old: o.weight, New: n.weight})
+			}
+		}
+	}
+	return entries
+}
+
+// endpoint holds what we audit of an endpoint.
+type endpoint struct {
+	locality string
+	health   string
+	weight   string
+}
+
+// endpoints returns the endpoints of cl by address and the weights of its localities.
+func endpoints(cl *xdspb2.Cluster) (map[string]endpoint, map[string]string) {
+	eps := map[string]endpoint{}
+	locs := map[string]string{}
+	for _, lle := range cl.GetLoadAssignment().GetEndpoints() {
+		loc := cache.Locality(lle.GetLocality())
+		locs[loc] = strconv.FormatUint(uint64(lle.GetLoadBalancingWeight().GetValue()), 10)
+		for _, lb := range lle.GetLbEndpoints() {
+			eps[cache.EndpointAddr(lb.GetEndpoint())] = endpoint{
+				locality: loc,
+				health:   lb.GetHealthStatus().String(),
+				weight:   strconv.FormatUint(uint64(lb.GetLoadBalancingWeight().GetValue()), 10),
+			}
+		}
+	}
+	return eps, locs
+}
+
+func endpointKeys(m map[string]endpoint) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func localityKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// union returns the sorted union of a and b.
+func union(a, b []string) []string {
+	seen := map[string]struct{}{}
+	keys := []string{}
+	for _, k := range append(a, b...) {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	edspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/miekg/xds/pkg/cache"
+)
+
+func newCluster(endpoints ...string) *xdspb2.Cluster {
+	lle := &edspb2.LocalityLbEndpoints{Locality: &corepb2.Locality{Region: "us"}}
+	for _, e := range endpoints {
+		addr, _ := cache.ParseEndpoint(e)
+		lle.LbEndpoints = append(lle.LbEndpoints, &edspb2.LbEndpoint{HostIdentifier: &edspb2.LbEndpoint_Endpoint{Endpoint: &edspb2.Endpoint{Address: addr}}})
+	}
+	return &xdspb2.Cluster{Name: "a", LoadAssignment: &xdspb2.ClusterLoadAssignment{ClusterName: "a", Endpoints: []*edspb2.LocalityLbEndpoints{lle}}}
+}
+
+func TestDiff(t *testing.T) {
+	old := newCluster("127.0.0.1:80", "127.0.0.2:80")
+	new := newCluster("127.0.0.1:80", "127.0.0.3:80")
+	new.LoadAssignment.Endpoints[0].LbEndpoints[0].HealthStatus = corepb2.HealthStatus_DRAINING
+	new.LoadAssignment.Endpoints[0].LbEndpoints[0].LoadBalancingWeight = &wrapperspb.UInt32Value{Value: 5}
+	new.LoadAssignment.Endpoints[0].LoadBalancingWeight = &wrapperspb.UInt32Value{Value: 2}
+	cache.SetLoadInMetadata(new, "us", 10) // not audited
+
+	expect := []Entry{
+		{Operation: SetLocalityWeight, Cluster: "a", Locality: "us", Old: "0", New: "2"},
+		{Operation: SetHealth, Cluster: "a", Endpoint: "127.0.0.1:80", Locality: "us", Old: "UNKNOWN", New: "DRAINING"},
+		{Operation: SetWeight, Cluster: "a", Endpoint: "127.0.0.1:80", Locality: "us", Old: "0", New: "5"},
+		{Operation: RemoveEndpoint, Cluster: "a", Endpoint: "127.0.0.2:80", Locality: "us", Old: "us"},
+		{Operation: AddEndpoint, Cluster: "a", Endpoint: "127.0.0.3:80", Locality: "us", New: "us"},
+	}
+	entries := Diff(old, new)
+	if len(entries) != len(expect) {
+		t.Fatalf("Expected %d entries, got %d: %+v", len(expect), len(entries), entries)
+	}
+	for i := range expect {
+		if entries[i] != expect[i] {
+			t.Errorf("Entry %d, expected %+v, got %+v", i, expect[i], entries[i])
+		}
+	}
+
+	if entries := Diff(nil, new); len(entries) != 1 || entries[0].Operation != CreateCluster {
+		t.Errorf("Expected %s, got %+v", CreateCluster, entries)
+	}
+	if entries := Diff(old, nil); len(entries) != 1 || entries[0].Operation != DeleteCluster {
+		t.Errorf("Expected %s, got %+v", DeleteCluster, entries)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	c := cache.New()
+	c.Insert(newCluster("127.0.0.1:80", "127.0.0.2:80"))
+
+	// only the change the observer was handed to is collected.
+	changes := Changes{}
+	if err := c.SetEndpointHealth("a", "127.0.0.1:80", corepb2.HealthStatus_DRAINING, changes.Observe); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetEndpointWeight("a", "127.0.0.2:80", 5); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Operation != SetHealth || changes[0].Endpoint != "127.0.0.1:80" {
+		t.Errorf("Expected %s of %s, got %+v", SetHealth, "127.0.0.1:80", changes)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Caller{Identity: "alice", Peer: "127.0.0.1:4000"}, Entry{Operation: SetHealth, Cluster: "a", Endpoint: "127.0.0.1:80", New: "DRAINING"})
+	l.Record(Caller{Identity: "bob"}, Entry{Operation: SetHealth, Cluster: "b", Endpoint: "127.0.0.2:80", New: "DRAINING"})
+	l.Close()
+
+	// reopening appends.
+	if l, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Record(Caller{Identity: "alice"}, Entry{Operation: SetWeight, Cluster: "a", Endpoint: "127.0.0.1:80", New: "5"})
+
+	tests := []struct {
+		q     Query
+		count int
+	}{
+		{Query{}, 3},
+		{Query{Identity: "alice"}, 2},
+		{Query{Identity: "alice", Operation: SetHealth}, 1},
+		{Query{Cluster: "b"}, 1},
+		{Query{Limit: 1}, 1},
+	}
+	for i, tc := range tests {
+		entries, err := l.Query(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != tc.count {
+			t.Errorf("Test %d, expected %d entries, got %d", i, tc.count, len(entries))
+		}
+	}
+
+	entries, _ := l.Query(Query{Limit: 1})
+	if e := entries[0]; e.Operation != SetWeight || e.Identity != "alice" || e.Time.IsZero() {
+		t.Errorf("Expected the last entry, got %+v", e)
+	}
+}
@@ -1,50 +1,264 @@
 package cache
 
 import (
+	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/golang/protobuf/proto"
+	clog "github.com/miekg/xds/pkg/log"
 	deep "github.com/mitchellh/copystructure"
 )
 
+var log = clog.New(clog.Cache)
+
 // Clusters holds the current clusters. For each cluster we only keep the ClusterLoadAssignments, for ClusterType
-// queries we will create a reply on-the-fly. We don't care about node-id's, but we do check the version of the
-// incoming reply to see if we have a newer one.
+// queries we will create a reply on-the-fly. What a node gets to see can be restricted with views, see View.
+//
+// Each cluster and each ClusterLoadAssignment carries its own version. These are handed out from a single
+// counter, so a newer resource always has a higher version, no matter which cluster it belongs to.
 type Cluster struct {
 	mu      sync.RWMutex
-	c       map[string]*xdspb2.Cluster
+	c       map[string]*entry
 	version uint64 // if anything changes this gets a new version.
+	removed uint64 // version of the last removal of a cluster.
+	views   []*View
+	dirty   map[string]struct{} // clusters changed at runtime, see MarkDirty.
+	store   Store               // if not nil every change is journaled here, see SetStore.
+	pending map[string][]byte   // changes to be written to store, a nil value is a delete, see journal.
+
+	jmu sync.Mutex // serializes writes to store, see flush.
+
+	loadFlushed time.Time // last time a load change was flushed to store, see modifyWithoutVersionUpdate.
+
+	wmu      sync.Mutex
+	watchers map[chan struct{}]struct{}
+}
+
+// entry is a cluster as stored in the cache.
+type entry struct {
+	cluster  *xdspb2.Cluster
+	version  uint64 // version of the cluster, without the load assignment.
+	eversion uint64 // version of the load assignment (endpoints).
 }
 
 func New() *Cluster {
-	return &Cluster{c: make(map[string]*xdspb2.Cluster)}
+	return &Cluster{
+		c:        make(map[string]*entry),
+		dirty:    make(map[string]struct{}),
+		pending:  make(map[string][]byte),
+		watchers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Observer is called with the old and the new cluster for every cluster a change touches, old is nil for a
+// cluster that is created and new is nil for one that is deleted. The methods that change clusters take
+// optional observers, these are called while the cache is locked, so they see exactly that change and nothing
+// made by others. An observer must not modify the clusters, keep them or call into the cache.
+type Observer func(old, new *xdspb2.Cluster)
+
+// observe calls all observers in obs.
+func observe(obs []Observer, old, new *xdspb2.Cluster) {
+	for _, o := range obs {
+		o(old, new)
+	}
+}
+
+// Insert inserts the cluster into the cache. Only the versions of the parts that changed (the cluster itself
+// and/or its endpoints) are updated. If nothing changed this is a noop.
+func (c *Cluster) Insert(ep *xdspb2.Cluster, obs ...Observer) {
+	c.Update([]*xdspb2.Cluster{ep}, nil, obs...)
+}
+
+// Delete removes the cluster from the cache. Because the set of clusters changes, this gets a new version.
+func (c *Cluster) Delete(name string, obs ...Observer) {
+	c.Update(nil, []string{name}, obs...)
 }
 
-func (c *Cluster) Insert(ep *xdspb2.Cluster) {
+// Update inserts the clusters in insert and deletes the ones named in remove in one go: clients see either
+// none or all of the changes. All parts that change get the same new version. The observers in obs are called
+// for each cluster that changed.
+func (c *Cluster) Update(insert []*xdspb2.Cluster, remove []string, obs ...Observer) {
+	c.update(insert, remove, nil, false, obs)
+}
+
+// Reload is like Update, but also replaces the views with views, all in the same update.
+func (c *Cluster) Reload(insert []*xdspb2.Cluster, remove []string, views []*View, obs ...Observer) {
+	c.update(insert, remove, views, true, obs)
+}
+
+// update implements Update, Reload and SetViews. The views are only replaced if setViews is true.
+func (c *Cluster) update(insert []*xdspb2.Cluster, remove []string, views []*View, setViews bool, obs []Observer) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	version := c.version + 1
+	changed := setViews && c.setViews(views, version)
+	for _, ep := range insert {
+		if c.insert(ep, version, obs) {
+			changed = true
+		}
+	}
+	for _, name := range remove {
+		e, ok := c.c[name]
+		if !ok {
+			continue
+		}
+		delete(c.c, name)
+		c.journal(name, nil)
+		c.removed = version
+		changed = true
+		observe(obs, e.cluster, nil)
+	}
+	if !changed {
+		c.mu.Unlock()
+		return
+	}
+	c.version = version
+	c.updateMetrics()
+	c.mu.Unlock()
+
+	c.flush()
+	c.notify()
+}
+
+// insert inserts ep and gives the parts that changed version. It returns true if anything changed, in which
+// case the observers in obs are called. The caller must hold c.mu.
+func (c *Cluster) insert(ep *xdspb2.Cluster, version uint64, obs []Observer) bool {
+	e, ok := c.c[ep.GetName()]
+	if !ok {
+		c.c[ep.GetName()] = &entry{cluster: ep, version: version, eversion: version}
+		c.journal(ep.GetName(), ep)
+		observe(obs, nil, ep)
+		return true
+	}
 
-	c.version += 1
-	c.c[ep.GetName()] = ep
+	old := e.cluster
+	clusterChanged, endpointsChanged := diff(old, ep)
+	e.cluster = ep
+	if !clusterChanged && !endpointsChanged {
+		return false
+	}
+	if clusterChanged {
+		e.version = version
+	}
+	if endpointsChanged {
+		e.eversion = version
+	}
+	c.journal(ep.GetName(), ep)
+	observe(obs, old, ep)
+	return true
 }
 
+// InsertWithoutVersionUpdate inserts the cluster, but leaves the versions as is.
 func (c *Cluster) InsertWithoutVersionUpdate(ep *xdspb2.Cluster) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.c[ep.GetName()] = ep
+	if e, ok := c.c[ep.GetName()]; ok {
+		e.cluster = ep
+	} else {
+		c.c[ep.GetName()] = &entry{cluster: ep}
+	}
+	c.journal(ep.GetName(), ep)
+	c.mu.Unlock()
+
+	c.flush()
+}
+
+// modify applies f to a copy of the cluster name and inserts the result, all while holding c.mu, so a change made
+// by someone else can't get lost in between. If f returns an error the cluster is left alone. The observers in
+// obs are called if the cluster changed.
+func (c *Cluster) modify(name string, f func(*xdspb2.Cluster) error, obs ...Observer) error {
+	c.mu.Lock()
+	cl, err := c.modified(name, f)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	version := c.version + 1
+	if !c.insert(cl, version, obs) {
+		c.mu.Unlock()
+		return nil
+	}
+	c.version = version
+	c.updateMetrics()
+	c.mu.Unlock()
+
+	c.flush()
+	c.notify()
+	return nil
+}
+
+// loadFlushInterval is how often load changes are flushed to the store at most.
+const loadFlushInterval = 10 * time.Second
+
+// modifyWithoutVersionUpdate is like modify, but leaves the versions as is and doesn't notify the watchers. This
+// is used to set the load, which doesn't warrant a new version. Load reports come in every few seconds, so the
+// change is journaled but only flushed if the last load flush is more than loadFlushInterval ago; otherwise it
+// is written with the next flush.
+func (c *Cluster) modifyWithoutVersionUpdate(name string, f func(*xdspb2.Cluster) error) error {
+	c.mu.Lock()
+	cl, err := c.modified(name, f)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.c[name].cluster = cl
+	c.journal(name, cl)
+	due := c.store != nil && time.Since(c.loadFlushed) >= loadFlushInterval
+	if due {
+		c.loadFlushed = time.Now()
+	}
+	c.mu.Unlock()
+
+	if due {
+		c.flush()
+	}
+	return nil
+}
+
+// modified returns a copy of the cluster name with f applied to it. The caller must hold c.mu.
+func (c *Cluster) modified(name string, f func(*xdspb2.Cluster) error) (*xdspb2.Cluster, error) {
+	e, ok := c.c[name]
+	if !ok {
+		return nil, notFoundError(name)
+	}
+	dc, _ := deep.Copy(e.cluster)
+	cl := dc.(*xdspb2.Cluster)
+	if err := f(cl); err != nil {
+		return nil, err
+	}
+	return cl, nil
 }
 
+// notFoundError is returned when a cluster to be changed doesn't exist.
+type notFoundError string
+
+func (e notFoundError) Error() string { return fmt.Sprintf("cluster %q not found", string(e)) }
+
+// Retrieve returns a copy of the cluster and its version.
 func (c *Cluster) Retrieve(name string) (*xdspb2.Cluster, uint64) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	ep, ok := c.c[name]
+	e, ok := c.c[name]
 	if !ok {
 		return nil, 0
 	}
-	dc, _ := deep.Copy(ep)
-	return dc.(*xdspb2.Cluster), c.version
+	dc, _ := deep.Copy(e.cluster)
+	return dc.(*xdspb2.Cluster), e.version
+}
+
+// Versions returns the version of the cluster and the version of its endpoints. If the cluster isn't found, both
+// are zero.
+func (c *Cluster) Versions(name string) (uint64, uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.c[name]
+	if !ok {
+		return 0, 0
+	}
+	return e.version, e.eversion
 }
 
 // All returns all cluster names in alphabetical order available in the cache.
@@ -60,7 +274,7 @@ func (c *Cluster) All() []string {
 	return keys
 }
 
-// Version returns the version of the cluster.
+// Version returns the version of the cache, this is the highest version of any cluster or endpoint in it.
 func (c *Cluster) Version() uint64 {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -68,8 +282,60 @@ func (c *Cluster) Version() uint64 {
 	return c.version
 }
 
+// Removed returns the version of the last removal of a cluster.
+func (c *Cluster) Removed() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.removed
+}
+
+// diff compares a and b and reports if the clusters differ (not looking at the load assignment) and if the
+// load assignments differ.
+func diff(a, b *xdspb2.Cluster) (cluster, endpoints bool) {
+	endpoints = !proto.Equal(a.GetLoadAssignment(), b.GetLoadAssignment())
+
+	// compare without the load assignments, by temporarily removing them.
+	la, lb := a.LoadAssignment, b.LoadAssignment
+	a.LoadAssignment, b.LoadAssignment = nil, nil
+	cluster = !proto.Equal(a, b)
+	a.LoadAssignment, b.LoadAssignment = la, lb
+
+	return cluster, endpoints
+}
+
+// Watch returns a channel that receives a value whenever the version of the cache changes. Notifications are
+// coalesced: a slow reader sees a single notification for multiple changes. The returned function stops the
+// watch and must be called when the caller is done.
+func (c *Cluster) Watch() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	c.wmu.Lock()
+	c.watchers[ch] = struct{}{}
+	c.wmu.Unlock()
+
+	return ch, func() {
+		c.wmu.Lock()
+		delete(c.watchers, ch)
+		c.wmu.Unlock()
+	}
+}
+
+// notify wakes up all watchers.
+func (c *Cluster) notify() {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	for ch := range c.watchers {
+		select {
+		case ch <- struct{}{}:
+		default: // already has a pending notification
+		}
+	}
+}
+
 const (
-	WeightKind = "weight" // Key name in metadata where the weight is stored.
-	LoadKind   = "load"   // Key name in the metadata where the load is stored.
-	HashKind   = "hash"   // hash of the textpb cluster definition.
+	LoadKind = "load" // Key name in the metadata where the load is stored.
+	HashKind = "hash" // hash of the textpb cluster definition.
+	ViewKind = "view" // Key name in the metadata where the view labels are stored.
 )
@@ -0,0 +1,496 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	edspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	clusterpb3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corepb3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerpb3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	loadpb2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/miekg/xds/pkg/resource"
+)
+
+func newCluster(name, addr string) *xdspb2.Cluster {
+	ep := &edspb2.Endpoint{
+		Address: &corepb2.Address{Address: &corepb2.Address_SocketAddress{
+			SocketAddress: &corepb2.SocketAddress{Address: addr, PortSpecifier: &corepb2.SocketAddress_PortValue{PortValue: 80}},
+		}},
+	}
+	return &xdspb2.Cluster{
+		Name: name,
+		LoadAssignment: &xdspb2.ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints: []*edspb2.LocalityLbEndpoints{{
+				LbEndpoints: []*edspb2.LbEndpoint{{HostIdentifier: &edspb2.LbEndpoint_Endpoint{Endpoint: ep}}},
+			}},
+		},
+	}
+}
+
+func TestVersions(t *testing.T) {
+	c := New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+	c.Insert(newCluster("b", "127.0.0.2"))
+
+	if v, e := c.Versions("b"); v != 2 || e != 2 {
+		t.Fatalf("Expected versions %d/%d, got %d/%d", 2, 2, v, e)
+	}
+
+	// Inserting the same cluster doesn't change anything.
+	c.Insert(newCluster("b", "127.0.0.2"))
+	if v := c.Version(); v != 2 {
+		t.Fatalf("Expected version %d, got %d", 2, v)
+	}
+
+	// Changing health only updates the endpoints of b.
+	b, _ := c.Retrieve("b")
+	ep := b.GetLoadAssignment().Endpoints[0].LbEndpoints[0].GetEndpoint()
+	c.SetHealth(&healthpb2.EndpointHealthResponse{
+		EndpointsHealth: []*healthpb2.EndpointHealth{{Endpoint: ep, HealthStatus: corepb2.HealthStatus_DRAINING}},
+	})
+	if v, e := c.Versions("b"); v != 2 || e != 3 {
+		t.Fatalf("Expected versions %d/%d, got %d/%d", 2, 3, v, e)
+	}
+	if v, e := c.Versions("a"); v != 1 || e != 1 {
+		t.Fatalf("Expected versions %d/%d, got %d/%d", 1, 1, v, e)
+	}
+
+	_, version, err := c.Resources(nil, resource.ClusterType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("Expected cluster version %d, got %d", 2, version)
+	}
+	_, version, err = c.Resources(nil, resource.EndpointType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 3 {
+		t.Errorf("Expected endpoint version %d, got %d", 3, version)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	c := New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+	c.Insert(newCluster("b", "127.0.0.2"))
+
+	c.Delete("b")
+	if x := c.All(); len(x) != 1 || x[0] != "a" {
+		t.Fatalf("Expected only cluster %q, got %v", "a", x)
+	}
+
+	resources, version, err := c.Resources(nil, resource.ClusterType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 {
+		t.Errorf("Expected %d resource, got %d", 1, len(resources))
+	}
+	if version != 3 {
+		t.Errorf("Expected version %d, got %d", 3, version)
+	}
+
+	// deleting an unknown cluster is a noop
+	c.Delete("b")
+	if v := c.Version(); v != 3 {
+		t.Errorf("Expected version %d, got %d", 3, v)
+	}
+
+	// a stream skips the removed cluster, Fetch returns an error for it.
+	if resources, _, _ := c.Resources(nil, resource.ClusterType, []string{"a", "b"}); len(resources) != 1 {
+		t.Errorf("Expected %d resource, got %d", 1, len(resources))
+	}
+	if _, err := c.Fetch(&xdspb2.DiscoveryRequest{TypeUrl: resource.ClusterType, ResourceNames: []string{"a", "b"}}); err == nil {
+		t.Errorf("Expected error when fetching unknown cluster %q", "b")
+	}
+}
+
+func TestResourcesV3(t *testing.T) {
+	c := New()
+	cl := newCluster("a", "127.0.0.1")
+	cl.EdsClusterConfig = &xdspb2.Cluster_EdsClusterConfig{
+		EdsConfig: &corepb2.ConfigSource{ConfigSourceSpecifier: &corepb2.ConfigSource_Ads{Ads: &corepb2.AggregatedConfigSource{}}},
+	}
+	c.Insert(cl)
+
+	resources, _, err := c.Resources(nil, resource.ClusterType3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl3 := &clusterpb3.Cluster{}
+	if err := ptypes.UnmarshalAny(resources[0].Any, cl3); err != nil {
+		t.Fatal(err)
+	}
+	if cl3.Name != "a" {
+		t.Errorf("Expected cluster %q, got %q", "a", cl3.Name)
+	}
+	if v := cl3.GetEdsClusterConfig().GetEdsConfig().GetResourceApiVersion(); v != corepb3.ApiVersion_V3 {
+		t.Errorf("Expected EDS config to use %s, got %s", corepb3.ApiVersion_V3, v)
+	}
+
+	resources, _, err = c.Resources(nil, resource.ListenerType3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lst := &listenerpb3.Listener{}
+	if err := ptypes.UnmarshalAny(resources[0].Any, lst); err != nil {
+		t.Fatal(err)
+	}
+	if x := lst.GetApiListener().GetApiListener().GetTypeUrl(); x != resource.HttpConnManagerType3 {
+		t.Errorf("Expected API listener of type %s, got %s", resource.HttpConnManagerType3, x)
+	}
+}
+
+func TestViews(t *testing.T) {
+	c := New()
+	c.Insert(newCluster("prod-a", "127.0.0.1"))
+	c.Insert(newCluster("staging-a", "127.0.0.2"))
+	staging := newCluster("shared", "127.0.0.3")
+	staging.Metadata = &corepb2.Metadata{FilterMetadata: map[string]*structpb.Struct{
+		ViewKind: {Fields: map[string]*structpb.Value{"envs": {Kind: &structpb.Value_StringValue{StringValue: "staging"}}}},
+	}}
+	c.Insert(staging)
+
+	c.SetViews([]*View{
+		{Name: "staging", Match: Match{Cluster: "staging"}, MetadataKey: "envs"},
+		{Name: "prod", Match: Match{Cluster: "prod"}, Clusters: []string{"prod-*"}, Weights: map[string]map[string]uint32{"*": {"": 7}}},
+	})
+	if v := c.Version(); v != 4 {
+		t.Errorf("Expected version %d, got %d", 4, v)
+	}
+
+	tests := []struct {
+		node     *corepb2.Node
+		clusters []string
+	}{
+		{&corepb2.Node{Cluster: "staging"}, []string{"shared"}},
+		{&corepb2.Node{Cluster: "prod"}, []string{"prod-a"}},
+		{&corepb2.Node{Cluster: "dev"}, []string{"prod-a", "shared", "staging-a"}},
+	}
+	for i, tc := range tests {
+		resources, _, err := c.Resources(tc.node, resource.EndpointType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, r := range resources {
+			names = append(names, r.Name)
+		}
+		if !reflect.DeepEqual(names, tc.clusters) {
+			t.Errorf("Test %d, expected clusters %v, got %v", i, tc.clusters, names)
+		}
+	}
+
+	resources, _, _ := c.Resources(&corepb2.Node{Cluster: "prod"}, resource.EndpointType, nil)
+	cla := &xdspb2.ClusterLoadAssignment{}
+	if err := ptypes.UnmarshalAny(resources[0].Any, cla); err != nil {
+		t.Fatal(err)
+	}
+	if w := cla.GetEndpoints()[0].GetLoadBalancingWeight().GetValue(); w != 7 {
+		t.Errorf("Expected locality weight %d, got %d", 7, w)
+	}
+}
+
+func TestViewWeights(t *testing.T) {
+	c := New()
+	cl := newCluster("helloworld", "127.0.0.1")
+	eu := newCluster("helloworld", "127.0.0.2").LoadAssignment.Endpoints[0]
+	cl.LoadAssignment.Endpoints[0].Locality = &corepb2.Locality{Region: "us", Zone: "a"}
+	eu.Locality = &corepb2.Locality{Region: "eu"}
+	cl.LoadAssignment.Endpoints = append(cl.LoadAssignment.Endpoints, eu)
+	c.Insert(cl)
+
+	c.SetViews([]*View{{Name: "weights", Weights: map[string]map[string]uint32{"hello*": {"us/a": 1, "eu": 10, "a": 5}}}})
+
+	resources, _, err := c.Resources(&corepb2.Node{}, resource.EndpointType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cla := &xdspb2.ClusterLoadAssignment{}
+	if err := ptypes.UnmarshalAny(resources[0].Any, cla); err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range []uint32{1, 10} {
+		if x := cla.GetEndpoints()[i].GetLoadBalancingWeight().GetValue(); x != w {
+			t.Errorf("Expected weight %d for locality %q, got %d", w, Locality(cla.GetEndpoints()[i].GetLocality()), x)
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	c := New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+
+	if err := c.AddEndpoint("a", "127.0.0.2:8080", "us/zone-a", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddEndpoint("a", "127.0.0.2:8080", "us/zone-a", 3); err == nil {
+		t.Errorf("Expected error when adding an existing endpoint")
+	}
+	if err := c.AddEndpoint("a", "localhost:8080", "", 0); err == nil {
+		t.Errorf("Expected error when adding an endpoint without IP address")
+	}
+	if _, e := c.Versions("a"); e != 2 {
+		t.Errorf("Expected endpoint version %d, got %d", 2, e)
+	}
+
+	a, _ := c.Retrieve("a")
+	eps := a.GetLoadAssignment().GetEndpoints()
+	if len(eps) != 2 {
+		t.Fatalf("Expected %d localities, got %d", 2, len(eps))
+	}
+	if l := Locality(eps[1].GetLocality()); l != "us/zone-a" {
+		t.Errorf("Expected locality %q, got %q", "us/zone-a", l)
+	}
+	if w := eps[1].GetLbEndpoints()[0].GetLoadBalancingWeight().GetValue(); w != 3 {
+		t.Errorf("Expected weight %d, got %d", 3, w)
+	}
+
+	if err := c.RemoveEndpoint("a", "127.0.0.2:8080"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveEndpoint("a", "127.0.0.2:8080"); err == nil {
+		t.Errorf("Expected error when removing an unknown endpoint")
+	}
+	a, _ = c.Retrieve("a")
+	if x := len(a.GetLoadAssignment().GetEndpoints()); x != 1 {
+		t.Errorf("Expected %d locality, got %d", 1, x)
+	}
+}
+
+func TestConcurrentModify(t *testing.T) {
+	c := New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+
+	// each goroutine adds its own endpoint, none of these should get lost.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := c.AddEndpoint("a", fmt.Sprintf("127.0.1.%d:80", i), "", 0); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	a, _ := c.Retrieve("a")
+	if x := len(a.GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints()); x != 21 {
+		t.Errorf("Expected %d endpoints, got %d", 21, x)
+	}
+}
+
+func TestReportHealthKeepsDrain(t *testing.T) {
+	c := New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+	a, _ := c.Retrieve("a")
+	req := &healthpb2.EndpointHealthResponse{
+		EndpointsHealth: []*healthpb2.EndpointHealth{{Endpoint: a.LoadAssignment.Endpoints[0].LbEndpoints[0].GetEndpoint(), HealthStatus: corepb2.HealthStatus_HEALTHY}},
+	}
+
+	// a health checker keeps reporting while the endpoint is drained, the drain must stick.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.ReportHealth("", req)
+		}
+	}()
+	if err := c.SetEndpointHealth("a", "", corepb2.HealthStatus_DRAINING); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	a, _ = c.Retrieve("a")
+	if h := a.GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints()[0].GetHealthStatus(); h != corepb2.HealthStatus_DRAINING {
+		t.Errorf("Expected health %s, got %s", corepb2.HealthStatus_DRAINING, h)
+	}
+}
+
+func TestClusterHealth(t *testing.T) {
+	cl := newCluster("a", "127.0.0.1")
+	lbs := cl.LoadAssignment.Endpoints[0].LbEndpoints
+	cl.LoadAssignment.Endpoints[0].LbEndpoints = append(lbs, newCluster("a", "127.0.0.2").LoadAssignment.Endpoints[0].LbEndpoints...)
+	lbs = cl.LoadAssignment.Endpoints[0].LbEndpoints
+
+	tests := []struct {
+		health [2]corepb2.HealthStatus
+		exp    string
+	}{
+		{[2]corepb2.HealthStatus{corepb2.HealthStatus_HEALTHY, corepb2.HealthStatus_UNKNOWN}, "healthy"},
+		{[2]corepb2.HealthStatus{corepb2.HealthStatus_HEALTHY, corepb2.HealthStatus_DRAINING}, "degraded"},
+		{[2]corepb2.HealthStatus{corepb2.HealthStatus_UNHEALTHY, corepb2.HealthStatus_DRAINING}, "unhealthy"},
+	}
+	for i, tc := range tests {
+		lbs[0].HealthStatus, lbs[1].HealthStatus = tc.health[0], tc.health[1]
+		if h := clusterHealth(cl); h != tc.exp {
+			t.Errorf("Test %d, expected cluster health %q, got %q", i, tc.exp, h)
+		}
+	}
+	if h := clusterHealth(&xdspb2.Cluster{Name: "b"}); h != "unhealthy" {
+		t.Errorf("Expected cluster health %q without endpoints, got %q", "unhealthy", h)
+	}
+}
+
+func TestReportHealthNotFound(t *testing.T) {
+	c := New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+	a, _ := c.Retrieve("a")
+	req := &healthpb2.EndpointHealthResponse{
+		EndpointsHealth: []*healthpb2.EndpointHealth{{Endpoint: a.LoadAssignment.Endpoints[0].LbEndpoints[0].GetEndpoint(), HealthStatus: corepb2.HealthStatus_HEALTHY}},
+	}
+	if _, err := c.ReportHealth("a", req); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+	if _, err := c.ReportHealth("b", req); err == nil {
+		t.Errorf("Expected error for unknown cluster %q, got none", "b")
+	}
+}
+
+// mapStore is an in-memory Store.
+type mapStore map[string][]byte
+
+func (m mapStore) Keys() []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+func (m mapStore) Get(key string) ([]byte, bool)      { v, ok := m[key]; return v, ok }
+func (m mapStore) Put(key string, value []byte) error { m[key] = value; return nil }
+func (m mapStore) Delete(key string) error            { delete(m, key); return nil }
+
+func TestStore(t *testing.T) {
+	s := mapStore{}
+	c := New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+	c.Insert(newCluster("b", "127.0.0.1"))
+	if err := c.SetStore(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetEndpointHealth("a", "", corepb2.HealthStatus_DRAINING); err != nil {
+		t.Fatal(err)
+	}
+	c.Delete("b")
+
+	// load is journaled, but a following report only with the next flush.
+	load := &loadpb2.LoadStatsRequest{ClusterStats: []*edspb2.ClusterStats{{
+		ClusterName:           "a",
+		UpstreamLocalityStats: []*edspb2.UpstreamLocalityStats{{TotalSuccessfulRequests: 10}},
+	}}}
+	before := s["a"]
+	c.SetLoad(load)
+	if bytes.Equal(s["a"], before) {
+		t.Errorf("Expected load to be journaled")
+	}
+	before = s["a"]
+	c.SetLoad(load)
+	if !bytes.Equal(s["a"], before) {
+		t.Errorf("Expected second load report not to be flushed yet")
+	}
+	if a, _ := c.Retrieve("a"); TotalLoadFromMetadata(a) != 20 {
+		t.Errorf("Expected load %d, got %d", 20, TotalLoadFromMetadata(a))
+	}
+
+	// restart, the clusters are read from their files again, the state comes from the store.
+	c = New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+	if err := c.SetStore(s); err != nil {
+		t.Fatal(err)
+	}
+	if names := c.All(); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("Expected clusters %v, got %v", []string{"a"}, names)
+	}
+	a, _ := c.Retrieve("a")
+	if h := a.GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints()[0].GetHealthStatus(); h != corepb2.HealthStatus_DRAINING {
+		t.Errorf("Expected health %s after restart, got %s", corepb2.HealthStatus_DRAINING, h)
+	}
+	if l := TotalLoadFromMetadata(a); l != 10 {
+		t.Errorf("Expected load %d after restart, got %d", 10, l)
+	}
+}
+
+func TestStoreHash(t *testing.T) {
+	s := mapStore{}
+	c := New()
+	for _, name := range []string{"a", "b", "c"} {
+		cl := newCluster(name, "127.0.0.1")
+		SetHashInMetadata(cl, "1")
+		c.Insert(cl)
+	}
+	if err := c.SetStore(s); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := c.SetEndpointHealth(name, "", corepb2.HealthStatus_DRAINING); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// restart, the file of a is unchanged, the one of b changed and the one of c is gone.
+	c = New()
+	a := newCluster("a", "127.0.0.1")
+	SetHashInMetadata(a, "1")
+	b := newCluster("b", "127.0.0.1")
+	SetHashInMetadata(b, "2")
+	c.Insert(a)
+	c.Insert(b)
+	if err := c.SetStore(s); err != nil {
+		t.Fatal(err)
+	}
+	if names := c.All(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Expected clusters %v, got %v", []string{"a", "b"}, names)
+	}
+	a, _ = c.Retrieve("a")
+	if h := a.GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints()[0].GetHealthStatus(); h != corepb2.HealthStatus_DRAINING {
+		t.Errorf("Expected health %s for a, got %s", corepb2.HealthStatus_DRAINING, h)
+	}
+	b, _ = c.Retrieve("b")
+	if h := b.GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints()[0].GetHealthStatus(); h != corepb2.HealthStatus_UNKNOWN {
+		t.Errorf("Expected health %s for b, got %s", corepb2.HealthStatus_UNKNOWN, h)
+	}
+	if HashFromMetadata(b) != "2" {
+		t.Errorf("Expected hash %q for b, got %q", "2", HashFromMetadata(b))
+	}
+	if _, ok := s["c"]; ok {
+		t.Errorf("Expected c to be deleted from the store")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	c := New()
+	c.Insert(newCluster("a", "127.0.0.1"))
+	c.Update([]*xdspb2.Cluster{newCluster("b", "127.0.0.1"), newCluster("c", "127.0.0.1")}, []string{"a"})
+
+	if v := c.Version(); v != 2 {
+		t.Errorf("Expected version %d after a single update, got %d", 2, v)
+	}
+	if names := c.All(); !reflect.DeepEqual(names, []string{"b", "c"}) {
+		t.Errorf("Expected clusters %v, got %v", []string{"b", "c"}, names)
+	}
+	if r := c.Removed(); r != 2 {
+		t.Errorf("Expected removed version %d, got %d", 2, r)
+	}
+
+	c.Reload([]*xdspb2.Cluster{newCluster("d", "127.0.0.1")}, nil, []*View{{Name: "d", Clusters: []string{"d"}}})
+	if v := c.Version(); v != 3 {
+		t.Errorf("Expected version %d after a single reload, got %d", 3, v)
+	}
+	if views := c.Views(); len(views) != 1 {
+		t.Errorf("Expected %d view, got %d", 1, len(views))
+	}
+}
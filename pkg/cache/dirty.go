@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"sort"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// MarkDirty marks the cluster name as changed at runtime (i.e. not by reading its file). Watchers are notified
+// even if the cache itself didn't change.
+func (c *Cluster) MarkDirty(name string) {
+	c.mu.Lock()
+	c.dirty[name] = struct{}{}
+	c.mu.Unlock()
+
+	c.notify()
+}
+
+// Dirty returns the clusters, in alphabetical order, that have been marked dirty since the last call to Dirty.
+func (c *Cluster) Dirty() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.dirty))
+	for k := range c.dirty {
+		keys = append(keys, k)
+	}
+	c.dirty = make(map[string]struct{})
+	sort.Strings(keys)
+	return keys
+}
+
+// SetHash sets the hash in the metadata of cluster name. The versions are left alone, as the hash is only used
+// to detect changes to the cluster's file.
+func (c *Cluster) SetHash(name, hash string) {
+	c.mu.Lock()
+	e, ok := c.c[name]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	cl := proto.Clone(e.cluster).(*xdspb2.Cluster) // others may still hold a reference to e.cluster
+	SetHashInMetadata(cl, hash)
+	e.cluster = cl
+	c.journal(name, cl)
+	c.mu.Unlock()
+
+	c.flush()
+}
+
+// Stripped returns a copy of cl without the metadata that is set by xds itself, i.e. the hash and the load.
+func Stripped(cl *xdspb2.Cluster) *xdspb2.Cluster {
+	cl = proto.Clone(cl).(*xdspb2.Cluster)
+	if cl.Metadata == nil {
+		return cl
+	}
+	delete(cl.Metadata.FilterMetadata, HashKind)
+	delete(cl.Metadata.FilterMetadata, LoadKind)
+	if len(cl.Metadata.FilterMetadata) == 0 {
+		cl.Metadata = nil
+	}
+	return cl
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	edspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// AddEndpoint adds endpoint ("address:port") to cluster in locality ("region/zone/subzone"). If weight is not
+// zero it is set as the endpoint's load balancing weight. It is an error if the endpoint already exists in the
+// cluster.
+func (c *Cluster) AddEndpoint(cluster, endpoint, locality string, weight uint32, obs ...Observer) error {
+	addr, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	return c.modify(cluster, func(cl *xdspb2.Cluster) error {
+		if cl.LoadAssignment == nil {
+			cl.LoadAssignment = &xdspb2.ClusterLoadAssignment{ClusterName: cluster}
+		}
+		for _, ep := range cl.LoadAssignment.Endpoints {
+			for _, lb := range ep.GetLbEndpoints() {
+				if EndpointAddr(lb.GetEndpoint()) == endpoint {
+					return fmt.Errorf("endpoint %q already exists in cluster %q", endpoint, cluster)
+				}
+			}
+		}
+
+		lb := &edspb2.LbEndpoint{HostIdentifier: &edspb2.LbEndpoint_Endpoint{Endpoint: &edspb2.Endpoint{Address: addr}}}
+		if weight > 0 {
+			lb.LoadBalancingWeight = &wrapperspb.UInt32Value{Value: weight}
+		}
+		var lle *edspb2.LocalityLbEndpoints
+		for _, ep := range cl.LoadAssignment.Endpoints {
+			if Locality(ep.GetLocality()) == locality {
+				lle = ep
+				break
+			}
+		}
+		if lle == nil {
+			lle = &edspb2.LocalityLbEndpoints{Locality: ParseLocality(locality)}
+			cl.LoadAssignment.Endpoints = append(cl.LoadAssignment.Endpoints, lle)
+		}
+		lle.LbEndpoints = append(lle.LbEndpoints, lb)
+		return nil
+	}, obs...)
+}
+
+// RemoveEndpoint removes endpoint from cluster. Localities that are left without endpoints are removed as well.
+func (c *Cluster) RemoveEndpoint(cluster, endpoint string, obs ...Observer) error {
+	return c.modify(cluster, func(cl *xdspb2.Cluster) error {
+		done := false
+		endpoints := []*edspb2.LocalityLbEndpoints{}
+		for _, ep := range cl.GetLoadAssignment().GetEndpoints() {
+			lbs := []*edspb2.LbEndpoint{}
+			for _, lb := range ep.GetLbEndpoints() {
+				if EndpointAddr(lb.GetEndpoint()) == endpoint {
+					done = true
+					continue
+				}
+				lbs = append(lbs, lb)
+			}
+			if len(lbs) == 0 {
+				continue
+			}
+			ep.LbEndpoints = lbs
+			endpoints = append(endpoints, ep)
+		}
+		if !done {
+			return fmt.Errorf("endpoint %q not found in cluster %q", endpoint, cluster)
+		}
+		cl.LoadAssignment.Endpoints = endpoints
+		return nil
+	}, obs...)
+}
+
+// ParseEndpoint parses an endpoint in the form "address:port".
+func ParseEndpoint(endpoint string) (*corepb2.Address, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("endpoint %q does not have an IP address", endpoint)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint %q has an invalid port: %s", endpoint, err)
+	}
+	return &corepb2.Address{Address: &corepb2.Address_SocketAddress{
+		SocketAddress: &corepb2.SocketAddress{Address: host, PortSpecifier: &corepb2.SocketAddress_PortValue{PortValue: uint32(p)}},
+	}}, nil
+}
+
+// ParseLocality parses a locality in the form "region/zone/subzone", this is the reverse of Locality.
+func ParseLocality(locality string) *corepb2.Locality {
+	if locality == "" {
+		return nil
+	}
+	parts := strings.SplitN(locality, "/", 3)
+	loc := &corepb2.Locality{Region: parts[0]}
+	if len(parts) > 1 {
+		loc.Zone = parts[1]
+	}
+	if len(parts) > 2 {
+		loc.SubZone = parts[2]
+	}
+	return loc
+}
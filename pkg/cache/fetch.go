@@ -10,160 +10,192 @@ import (
 	routepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
 	httppb2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
 	listenerpb2 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v2"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/miekg/xds/pkg/resource"
+	deep "github.com/mitchellh/copystructure"
 )
 
+// Resource is a single xDS resource, together with its name and version.
+type Resource struct {
+	Name    string
+	Version uint64
+	Any     *any.Any
+}
+
 // Fetch fetches cluster data from the cluster. Here we probably deviate from the spec, as empty versions are allowed and we
 // will return the full list again. For versioning we use the highest version we see in the cache and use that as the version
-// in the reply.
+// in the reply. Asking for a cluster that doesn't exist is an error; streams use Resources, which skips these.
 func (c *Cluster) Fetch(req *xdspb2.DiscoveryRequest) (*xdspb2.DiscoveryResponse, error) {
-	var resources []*any.Any
 	if req.Node == nil {
 		req.Node = &corepb2.Node{Id: "ADS"}
 	}
+	for _, n := range req.ResourceNames {
+		if cl, _ := c.Retrieve(n); cl == nil {
+			return nil, fmt.Errorf("cluster %q not found", n)
+		}
+	}
+
+	resources, version, err := c.Resources(req.Node, req.TypeUrl, req.ResourceNames)
+	if err != nil {
+		return nil, err
+	}
+	return DiscoveryResponse(req.TypeUrl, version, resources), nil
+}
+
+// DiscoveryResponse returns a discovery response for type typeURL, containing resources.
+func DiscoveryResponse(typeURL string, version uint64, resources []Resource) *xdspb2.DiscoveryResponse {
+	anys := make([]*any.Any, len(resources))
+	for i := range resources {
+		anys[i] = resources[i].Any
+	}
+	versionInfo := strconv.FormatUint(version, 10)
+	return &xdspb2.DiscoveryResponse{VersionInfo: versionInfo, Resources: anys, TypeUrl: typeURL}
+}
+
+// Resources returns the resources of type typeURL with the given names, each with its own version. If names is
+// empty all resources are returned. Names that aren't found are skipped, on a stream these are clusters that
+// have been removed (unlike Fetch, which returns an error for them). The returned version is the highest
+// version of the returned resources, or the version of the last removal of a cluster if that is higher; this
+// makes sure removals are seen as a new version. Only the clusters visible to node are returned, see View.
+func (c *Cluster) Resources(node *corepb2.Node, typeURL string, names []string) ([]Resource, uint64, error) {
+	if resource.IsV3(typeURL) {
+		return c.resources3(node, typeURL, names)
+	}
+	switch typeURL {
+	case resource.EndpointType, resource.ClusterType, resource.ListenerType, resource.RouteConfigType:
+	default:
+		return nil, 0, fmt.Errorf("unrecognized/unsupported type %q:", typeURL)
+	}
 
-	switch req.TypeUrl {
-	case resource.EndpointType:
-		sort.Strings(req.ResourceNames)
-		clusters := req.ResourceNames
-		if len(req.ResourceNames) == 0 {
-			clusters = c.All()
+	snap := c.snapshot(node, names)
+	var resources []Resource
+	version := snap.removed
+	for _, e := range snap.entries {
+		if e.cluster == nil {
+			log.With("cluster", e.name, "node", node.GetId()).Debugf("Cluster %q not found", e.name)
+			continue
 		}
-		version := uint64(0)
-
-		for _, n := range clusters {
-			cluster, v := c.Retrieve(n)
-			if cluster == nil {
-				return nil, fmt.Errorf("cluster %q not found", n)
-			}
-			if v > version {
-				version = v
-			}
-			endpoints := xdspb2.ClusterLoadAssignment(*(cluster.GetLoadAssignment()))
-			data, err := MarshalResource(&endpoints)
-			if err != nil {
-				return nil, err
-			}
-			resources = append(resources, &any.Any{TypeUrl: req.TypeUrl, Value: data})
+		if !snap.view.Visible(node, e.cluster) {
+			log.With("cluster", e.name, "node", node.GetId()).Debugf("Cluster %q not visible to node %q", e.name, node.GetId())
+			continue
 		}
-		versionInfo := strconv.FormatUint(version, 10)
-		return &xdspb2.DiscoveryResponse{VersionInfo: versionInfo, Resources: resources, TypeUrl: req.TypeUrl}, nil
-
-	case resource.ClusterType:
-		sort.Strings(req.ResourceNames)
-		clusters := req.ResourceNames
-		if len(req.ResourceNames) == 0 {
-			clusters = c.All()
+
+		var (
+			msg proto.Message
+			v   = e.version
+		)
+		switch typeURL {
+		case resource.EndpointType:
+			v = e.eversion
+			snap.view.Apply(e.name, e.cluster.GetLoadAssignment())
+			endpoints := xdspb2.ClusterLoadAssignment(*(e.cluster.GetLoadAssignment()))
+			msg = &endpoints
+		case resource.ClusterType:
+			msg = e.cluster
+		case resource.ListenerType:
+			msg = listener(e.cluster)
+		case resource.RouteConfigType:
+			msg = routeConfiguration(e.cluster)
 		}
-		version := uint64(0)
-
-		for _, n := range clusters {
-			cluster, v := c.Retrieve(n)
-			if cluster == nil {
-				return nil, fmt.Errorf("cluster %q not found", n)
-			}
-			if v > version {
-				version = v
-			}
-			data, err := MarshalResource(cluster)
-			if err != nil {
-				return nil, err
-			}
-			resources = append(resources, &any.Any{TypeUrl: req.TypeUrl, Value: data})
+		if v > version {
+			version = v
 		}
-		versionInfo := strconv.FormatUint(version, 10)
-		return &xdspb2.DiscoveryResponse{VersionInfo: versionInfo, Resources: resources, TypeUrl: req.TypeUrl}, nil
-	case resource.ListenerType:
-		sort.Strings(req.ResourceNames)
-		clusters := req.ResourceNames
-		if len(req.ResourceNames) == 0 {
-			clusters = c.All()
+		data, err := MarshalResource(msg)
+		if err != nil {
+			return nil, 0, err
 		}
-		version := uint64(0)
-
-		for _, n := range clusters {
-			cluster, v := c.Retrieve(n)
-			if cluster == nil {
-				return nil, fmt.Errorf("cluster %q not found", n)
-			}
-			if v > version {
-				version = v
-			}
-
-			hcm := &httppb2.HttpConnectionManager{
-				RouteSpecifier: &httppb2.HttpConnectionManager_Rds{
-					Rds: &httppb2.Rds{
-						ConfigSource: &corepb2.ConfigSource{
-							ConfigSourceSpecifier: &corepb2.ConfigSource_Ads{Ads: &corepb2.AggregatedConfigSource{}},
-						},
-						RouteConfigName: cluster.Name,
-					},
-				},
-			}
-			hcmdata, _ := MarshalResource(hcm)
-			lst := &xdspb2.Listener{
-				Name: cluster.Name,
-				ApiListener: &listenerpb2.ApiListener{
-					ApiListener: &any.Any{
-						TypeUrl: resource.HttpConnManagerType,
-						Value:   hcmdata,
-					},
-				},
-			}
-			data, err := MarshalResource(lst)
-			if err != nil {
-				return nil, err
-			}
-			resources = append(resources, &any.Any{TypeUrl: req.TypeUrl, Value: data})
+		resources = append(resources, Resource{Name: e.name, Version: v, Any: &any.Any{TypeUrl: typeURL, Value: data}})
+	}
+	return resources, version, nil
+}
+
+// snapshot is a copy of the clusters, their versions and the view for a node, all taken under a single lock. This
+// makes sure the resources we build from it match the versions they're sent with.
+type snapshot struct {
+	view    *View
+	removed uint64
+	entries []snapshotEntry
+}
+
+// snapshotEntry is a cluster in a snapshot.
+type snapshotEntry struct {
+	name     string
+	cluster  *xdspb2.Cluster // a copy of the cluster, nil if it doesn't exist.
+	version  uint64
+	eversion uint64
+}
+
+// snapshot returns a snapshot of the clusters in names, or all clusters if names is empty, for node. The entries
+// are in alphabetical order.
+func (c *Cluster) snapshot(node *corepb2.Node, names []string) snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names = append([]string(nil), names...)
+	if len(names) == 0 {
+		for n := range c.c {
+			names = append(names, n)
 		}
-		versionInfo := strconv.FormatUint(version, 10)
-		return &xdspb2.DiscoveryResponse{VersionInfo: versionInfo, Resources: resources, TypeUrl: req.TypeUrl}, nil
-	case resource.RouteConfigType:
-		sort.Strings(req.ResourceNames)
-		clusters := req.ResourceNames
-		if len(req.ResourceNames) == 0 {
-			clusters = c.All()
+	}
+	sort.Strings(names)
+
+	snap := snapshot{view: c.viewFor(node), removed: c.removed, entries: make([]snapshotEntry, len(names))}
+	for i, n := range names {
+		snap.entries[i].name = n
+		e, ok := c.c[n]
+		if !ok {
+			continue
 		}
-		version := uint64(0)
-
-		for _, n := range clusters {
-			cluster, v := c.Retrieve(n)
-			if cluster == nil {
-				return nil, fmt.Errorf("cluster %q not found", n)
-			}
-			if v > version {
-				version = v
-			}
-
-			routec := &xdspb2.RouteConfiguration{
-				Name: cluster.Name,
-				VirtualHosts: []*routepb2.VirtualHost{
+		dc, _ := deep.Copy(e.cluster)
+		snap.entries[i].cluster = dc.(*xdspb2.Cluster)
+		snap.entries[i].version, snap.entries[i].eversion = e.version, e.eversion
+	}
+	return snap
+}
+
+// listener returns the API listener for cluster, it points to the route configuration with the same name.
+func listener(cluster *xdspb2.Cluster) *xdspb2.Listener {
+	hcm := &httppb2.HttpConnectionManager{
+		RouteSpecifier: &httppb2.HttpConnectionManager_Rds{
+			Rds: &httppb2.Rds{
+				ConfigSource: &corepb2.ConfigSource{
+					ConfigSourceSpecifier: &corepb2.ConfigSource_Ads{Ads: &corepb2.AggregatedConfigSource{}},
+				},
+				RouteConfigName: cluster.Name,
+			},
+		},
+	}
+	hcmdata, _ := MarshalResource(hcm)
+	return &xdspb2.Listener{
+		Name: cluster.Name,
+		ApiListener: &listenerpb2.ApiListener{
+			ApiListener: &any.Any{
+				TypeUrl: resource.HttpConnManagerType,
+				Value:   hcmdata,
+			},
+		},
+	}
+}
+
+// routeConfiguration returns the route configuration for cluster, it routes everything to cluster.
+func routeConfiguration(cluster *xdspb2.Cluster) *xdspb2.RouteConfiguration {
+	return &xdspb2.RouteConfiguration{
+		Name: cluster.Name,
+		VirtualHosts: []*routepb2.VirtualHost{
+			{
+				Domains: []string{cluster.Name}, // cluster.Name, here??
+				Routes: []*routepb2.Route{
 					{
-						Domains: []string{cluster.Name}, // cluster.Name, here??
-						Routes: []*routepb2.Route{
-							{
-								Match: &routepb2.RouteMatch{PathSpecifier: &routepb2.RouteMatch_Prefix{Prefix: ""}},
-								Action: &routepb2.Route_Route{
-									Route: &routepb2.RouteAction{
-										ClusterSpecifier: &routepb2.RouteAction_Cluster{Cluster: cluster.Name},
-									},
-								},
+						Match: &routepb2.RouteMatch{PathSpecifier: &routepb2.RouteMatch_Prefix{Prefix: ""}},
+						Action: &routepb2.Route_Route{
+							Route: &routepb2.RouteAction{
+								ClusterSpecifier: &routepb2.RouteAction_Cluster{Cluster: cluster.Name},
 							},
 						},
 					},
 				},
-			}
-
-			data, err := MarshalResource(routec)
-			if err != nil {
-				return nil, err
-			}
-			resources = append(resources, &any.Any{TypeUrl: req.TypeUrl, Value: data})
-		}
-		versionInfo := strconv.FormatUint(version, 10)
-		return &xdspb2.DiscoveryResponse{VersionInfo: versionInfo, Resources: resources, TypeUrl: req.TypeUrl}, nil
-
+			},
+		},
 	}
-	return nil, fmt.Errorf("unrecognized/unsupported type %q:", req.TypeUrl)
 }
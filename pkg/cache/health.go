@@ -1,12 +1,27 @@
 package cache
 
 import (
+	"fmt"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/golang/protobuf/ptypes/duration"
 )
 
 // SetHealth sets the health for clusters and or endpoints.
-func (c *Cluster) SetHealth(req *healthpb2.EndpointHealthResponse) (*healthpb2.HealthCheckSpecifier, error) {
+func (c *Cluster) SetHealth(req *healthpb2.EndpointHealthResponse, obs ...Observer) (*healthpb2.HealthCheckSpecifier, error) {
+	return c.setHealth(req, "", false, obs)
+}
+
+// ReportHealth sets the health for endpoints as reported by a health checker. Endpoints that are DRAINING are left
+// alone, as an operator has put them in that state. If cluster is not empty only endpoints in that cluster are
+// updated.
+func (c *Cluster) ReportHealth(cluster string, req *healthpb2.EndpointHealthResponse, obs ...Observer) (*healthpb2.HealthCheckSpecifier, error) {
+	return c.setHealth(req, cluster, true, obs)
+}
+
+func (c *Cluster) setHealth(req *healthpb2.EndpointHealthResponse, only string, keepDraining bool, obs []Observer) (*healthpb2.HealthCheckSpecifier, error) {
 	toChange := make([]string, len(req.EndpointsHealth))
 	health := make([]corepb2.HealthStatus, len(req.EndpointsHealth))
 	for i, ep := range req.EndpointsHealth {
@@ -16,30 +31,108 @@ func (c *Cluster) SetHealth(req *healthpb2.EndpointHealthResponse) (*healthpb2.H
 
 	// we lack a cluster name, so we iterate over *all* clusters that have this endpoint and set it's health,
 	// not sure if this is how it is supposed to work.
-	all := c.All()
-	for _, name := range all {
-		cluster, _ := c.Retrieve(name)
-
-		done := false
-		endpoints := cluster.GetLoadAssignment()
-		for _, ep := range endpoints.Endpoints {
-			for _, lb := range ep.GetLbEndpoints() {
-				epa := lb.GetEndpoint().GetAddress().GetSocketAddress()
-				for j, sa := range toChange {
-					if sa == epa.String() {
-						if lb.HealthStatus != health[j] {
+	names := c.All()
+	if only != "" {
+		names = []string{only}
+	}
+	for _, name := range names {
+		// the current health is checked under the same lock as the new one is set, so we don't overwrite a
+		// drain that comes in between. If the cluster is gone in the meantime there is nothing to do, unless
+		// we were asked to change that cluster.
+		err := c.modify(name, func(cluster *xdspb2.Cluster) error {
+			for _, ep := range cluster.GetLoadAssignment().GetEndpoints() {
+				for _, lb := range ep.GetLbEndpoints() {
+					epa := lb.GetEndpoint().GetAddress().GetSocketAddress()
+					for j, sa := range toChange {
+						if sa == epa.String() {
+							if keepDraining && lb.HealthStatus == corepb2.HealthStatus_DRAINING {
+								continue
+							}
 							lb.HealthStatus = health[j]
-							done = true
 						}
 					}
 				}
 			}
+			return nil
+		}, obs...)
+		if _, ok := err.(notFoundError); ok && only == "" {
+			continue
 		}
-		if done {
-			// we've updated something, write it back to the cache.
-			c.Insert(cluster)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	return &healthpb2.HealthCheckSpecifier{}, nil
 }
+
+// SetEndpointHealth sets the health of endpoint in cluster. If endpoint is empty all endpoints of the cluster
+// are set.
+func (c *Cluster) SetEndpointHealth(cluster, endpoint string, health corepb2.HealthStatus, obs ...Observer) error {
+	return c.modify(cluster, func(cl *xdspb2.Cluster) error {
+		done := false
+		for _, ep := range cl.GetLoadAssignment().GetEndpoints() {
+			for _, lb := range ep.GetLbEndpoints() {
+				if endpoint == "" || EndpointAddr(lb.GetEndpoint()) == endpoint {
+					lb.HealthStatus = health
+					done = true
+				}
+			}
+		}
+		if !done {
+			return fmt.Errorf("endpoint %q not found in cluster %q", endpoint, cluster)
+		}
+		return nil
+	}, obs...)
+}
+
+// HealthClusters returns the names of the clusters that have one or more of the endpoints in req. These are the
+// clusters that are changed when req is used to set the health.
+func (c *Cluster) HealthClusters(req *healthpb2.EndpointHealthResponse) []string {
+	addrs := map[string]struct{}{}
+	for _, ep := range req.GetEndpointsHealth() {
+		addrs[ep.GetEndpoint().GetAddress().GetSocketAddress().String()] = struct{}{}
+	}
+
+	clusters := []string{}
+	for _, name := range c.All() {
+		cluster, _ := c.Retrieve(name)
+		if cluster == nil {
+			continue
+		}
+	Found:
+		for _, ep := range cluster.GetLoadAssignment().GetEndpoints() {
+			for _, lb := range ep.GetLbEndpoints() {
+				if _, ok := addrs[lb.GetEndpoint().GetAddress().GetSocketAddress().String()]; ok {
+					clusters = append(clusters, name)
+					break Found
+				}
+			}
+		}
+	}
+	return clusters
+}
+
+// HealthCheckSpecifier returns the health checks and endpoints of all clusters. This is handed to Envoys that do
+// the health checking for us via HDS.
+func (c *Cluster) HealthCheckSpecifier() *healthpb2.HealthCheckSpecifier {
+	hs := &healthpb2.HealthCheckSpecifier{Interval: &duration.Duration{Seconds: 10}}
+	for _, name := range c.All() {
+		cluster, _ := c.Retrieve(name)
+		if cluster == nil {
+			continue
+		}
+		chc := &healthpb2.ClusterHealthCheck{ClusterName: name, HealthChecks: cluster.GetHealthChecks()}
+		for _, ep := range cluster.GetLoadAssignment().GetEndpoints() {
+			le := &healthpb2.LocalityEndpoints{Locality: ep.GetLocality()}
+			for _, lb := range ep.GetLbEndpoints() {
+				if e := lb.GetEndpoint(); e != nil {
+					le.Endpoints = append(le.Endpoints, e)
+				}
+			}
+			chc.LocalityEndpoints = append(chc.LocalityEndpoints, le)
+		}
+		hs.ClusterHealthChecks = append(hs.ClusterHealthChecks, chc)
+	}
+	return hs
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	loadpb2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
+)
+
+// Cache is what the server needs from a cache. Cluster implements it, other implementations can be used to
+// serve clusters from somewhere else, i.e. a service registry.
+type Cache interface {
+	// Fetch returns the response to a (non-streaming) discovery request.
+	Fetch(req *xdspb2.DiscoveryRequest) (*xdspb2.DiscoveryResponse, error)
+	// Resources returns the resources of typeURL for node, see Cluster.Resources.
+	Resources(node *corepb2.Node, typeURL string, names []string) ([]Resource, uint64, error)
+	// Version returns the version of the cache, it must increase on every change.
+	Version() uint64
+	// Watch returns a channel that receives a value when the cache changes and a function to stop the watch.
+	Watch() (<-chan struct{}, func())
+
+	// All returns the names of all clusters in alphabetical order.
+	All() []string
+	// Retrieve returns a copy of the cluster name and its version, or nil if it doesn't exist.
+	Retrieve(name string) (*xdspb2.Cluster, uint64)
+	// Insert inserts or replaces a cluster.
+	Insert(cl *xdspb2.Cluster, obs ...Observer)
+	// Delete deletes the cluster name.
+	Delete(name string, obs ...Observer)
+	// MarkDirty marks the cluster name as changed at runtime.
+	MarkDirty(name string)
+
+	// HealthCheckSpecifier returns the health checks for all clusters (HDS).
+	HealthCheckSpecifier() *healthpb2.HealthCheckSpecifier
+	// HealthClusters returns the clusters the health in req applies to.
+	HealthClusters(req *healthpb2.EndpointHealthResponse) []string
+	// ReportHealth sets the health reported by a health checker.
+	ReportHealth(cluster string, req *healthpb2.EndpointHealthResponse, obs ...Observer) (*healthpb2.HealthCheckSpecifier, error)
+	// SetEndpointHealth sets the health of endpoint, or all endpoints if empty, in cluster.
+	SetEndpointHealth(cluster, endpoint string, health corepb2.HealthStatus, obs ...Observer) error
+
+	// SetLoad sets the load reported in req (LRS).
+	SetLoad(req *loadpb2.LoadStatsRequest) (*loadpb2.LoadStatsResponse, error)
+	// SetEndpointWeight sets the weight of endpoint in cluster.
+	SetEndpointWeight(cluster, endpoint string, weight uint32, obs ...Observer) error
+	// SetLocalityWeight sets the weight of locality in cluster.
+	SetLocalityWeight(cluster, locality string, weight uint32, obs ...Observer) error
+
+	// AddEndpoint adds endpoint to cluster in locality.
+	AddEndpoint(cluster, endpoint, locality string, weight uint32, obs ...Observer) error
+	// RemoveEndpoint removes endpoint from cluster.
+	RemoveEndpoint(cluster, endpoint string, obs ...Observer) error
+}
+
+var _ Cache = (*Cluster)(nil)
@@ -1,16 +1,19 @@
 package cache
 
 import (
-	"strings"
+	"fmt"
 
 	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	loadpb2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
 	"github.com/golang/protobuf/ptypes/duration"
 	structpb "github.com/golang/protobuf/ptypes/struct"
-	"github.com/miekg/xds/pkg/log"
+	clog "github.com/miekg/xds/pkg/log"
 )
 
+// lrsLog logs load reports.
+var lrsLog = clog.New(clog.LRS)
+
 // SetLoad sets the load for clusters and or endpoints.
 func (c *Cluster) SetLoad(req *loadpb2.LoadStatsRequest) (*loadpb2.LoadStatsResponse, error) {
 	clusters := []string{}
@@ -20,71 +23,32 @@ func (c *Cluster) SetLoad(req *loadpb2.LoadStatsRequest) (*loadpb2.LoadStatsResp
 			continue
 		}
 
-		cl, _ := c.Retrieve(clusterStats.ClusterName)
-		if cl == nil {
-			log.Debugf("Load report for unknown cluster %s", clusterStats.ClusterName)
-			continue
-		}
+		err := c.modifyWithoutVersionUpdate(clusterStats.ClusterName, func(cl *xdspb2.Cluster) error {
+			done := false
+			endpoints := cl.GetLoadAssignment()
+			for _, upstreamStats := range clusterStats.UpstreamLocalityStats {
+				where := Locality(upstreamStats.GetLocality()) // this is also the metadata key for this load report in this cluster
 
-		// This is our hack to set the weights via load reporting.
-		for _, upstreamStats := range clusterStats.UpstreamLocalityStats {
-			for _, endpointStats := range upstreamStats.UpstreamEndpointStats {
-				weight := WeightFromMetadata(endpointStats)
-				// if one of them has it we assume the entire things is about changing weights
-				if weight > 0 {
-					return c.SetWeight(req)
+				// grpc reports: TotalSuccessfulRequests
+				totalSuccessLoad := upstreamStats.GetTotalSuccessfulRequests()
+				// check if any of the endpoints match the locality, if so, then set the load
+				// in the cluster's metadata
+				for _, ep := range endpoints.GetEndpoints() {
+					if Locality(ep.GetLocality()) == where {
+						SetLoadInMetadata(cl, where, totalSuccessLoad)
+						lrsLog.With("cluster", cl.Name).Debugf("Load report for %s, reporting %d for locality %s", cl.Name, totalSuccessLoad, where)
+						done = true
+					}
 				}
 			}
-		}
-
-		done := false
-		endpoints := cl.GetLoadAssignment()
-		for _, upstreamStats := range clusterStats.UpstreamLocalityStats {
-			// doing this string slice trick is (mem) wasteful, but ease the code (a little). Should
-			// be removed/improved. TODO(miek).
-			locs := []string{}
-			loc := upstreamStats.GetLocality()
-			if x := loc.GetRegion(); x != "" {
-				locs = append(locs, x)
-			}
-			if x := loc.GetZone(); x != "" {
-				locs = append(locs, x)
+			if !done {
+				return fmt.Errorf("unknown locality in cluster %q", cl.Name)
 			}
-			if x := loc.GetSubZone(); x != "" {
-				locs = append(locs, x)
-			}
-			where := strings.TrimSpace(strings.Join(locs, "/")) // this is also the metadata key for this load report in this cluster
-
-			// grpc reports: TotalSuccessfulRequests
-			totalSuccessLoad := upstreamStats.GetTotalSuccessfulRequests()
-			// check if any of the endpoints match the locality, if so, then set the load
-			// in the cluster's metadata
-			for _, ep := range endpoints.Endpoints {
-				locs := []string{}
-				loc := ep.GetLocality()
-				if x := loc.GetRegion(); x != "" {
-					locs = append(locs, x)
-				}
-				if x := loc.GetZone(); x != "" {
-					locs = append(locs, x)
-				}
-				if x := loc.GetSubZone(); x != "" {
-					locs = append(locs, x)
-				}
-				ewhere := strings.TrimSpace(strings.Join(locs, "/")) // this is also the metadata key for this load report in this cluster
-				if ewhere == where {
-					SetLoadInMetadata(cl, where, totalSuccessLoad)
-					log.Debugf("Load report for %s, reporting %d for locality %s", cl.Name, totalSuccessLoad, where)
-					done = true
-				}
-			}
-		}
-		if done {
-			// we've updated something, write it back to the cache.
-			c.InsertWithoutVersionUpdate(cl)
-			continue
+			return nil
+		})
+		if err != nil {
+			lrsLog.With("cluster", clusterStats.ClusterName).Debugf("Load report for %s: %s", clusterStats.ClusterName, err)
 		}
-		log.Debugf("Load report for unknown locality in cluster %s", clusterStats.ClusterName)
 	}
 	// if there wasn't an actual load report this was the initial ping that load "are coming", in that case
 	// node Id contains the cluster we're interested in, so put that in the cluster slice.
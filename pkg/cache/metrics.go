@@ -0,0 +1,59 @@
+package cache
+
+import (
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/miekg/xds/pkg/metrics"
+)
+
+var (
+	versionGauge  = metrics.NewGauge("cache", "version", "Version of the cache.")
+	clusterGauge  = metrics.NewGauge("cache", "clusters", "Clusters in the cache per health: healthy (all endpoints are), degraded (some are) or unhealthy (none are).", "health")
+	endpointGauge = metrics.NewGauge("cache", "endpoints", "Endpoints in the cache per health status.", "health")
+)
+
+// updateMetrics sets the cache's metrics. The caller must hold c.mu.
+func (c *Cluster) updateMetrics() {
+	versionGauge.Set(float64(c.version))
+
+	clusters := map[string]int{"healthy": 0, "degraded": 0, "unhealthy": 0}
+	health := map[string]int{}
+	for _, e := range c.c {
+		clusters[clusterHealth(e.cluster)]++
+		for _, ep := range e.cluster.GetLoadAssignment().GetEndpoints() {
+			for _, lb := range ep.GetLbEndpoints() {
+				health[lb.GetHealthStatus().String()]++
+			}
+		}
+	}
+	for h, n := range clusters {
+		clusterGauge.Set(float64(n), h)
+	}
+	endpointGauge.Reset()
+	for h, n := range health {
+		endpointGauge.Set(float64(n), h)
+	}
+}
+
+// clusterHealth returns "healthy" if all endpoints of cluster can take traffic, "unhealthy" if none can (or
+// there are none) and "degraded" otherwise. Endpoints that are HEALTHY, or UNKNOWN as Envoy uses those too,
+// can take traffic.
+func clusterHealth(cluster *xdspb2.Cluster) string {
+	up, total := 0, 0
+	for _, ep := range cluster.GetLoadAssignment().GetEndpoints() {
+		for _, lb := range ep.GetLbEndpoints() {
+			total++
+			switch lb.GetHealthStatus() {
+			case corepb2.HealthStatus_HEALTHY, corepb2.HealthStatus_UNKNOWN:
+				up++
+			}
+		}
+	}
+	switch {
+	case up == 0:
+		return "unhealthy"
+	case up < total:
+		return "degraded"
+	}
+	return "healthy"
+}
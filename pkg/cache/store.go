@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// Store is a key/value store the cache journals its clusters to, so the state of the cache (health, weights,
+// endpoints, load) survives a restart. The key is the cluster name, the value the marshalled cluster. See
+// the store package for a file based implementation.
+type Store interface {
+	Keys() []string
+	Get(key string) ([]byte, bool)
+	Put(key string, value []byte) error
+	Delete(key string) error
+}
+
+// SetStore replays the clusters in s into the cache, replacing the clusters with the same name. From then on
+// every change to the cache is written to s. This should be called before the cache is used to serve clients.
+//
+// Clusters that are read from a file carry the hash of that file. A stored cluster with a hash is only replayed
+// if the cluster in the cache has the same hash: if it differs the file changed while we were down, and if the
+// cluster isn't in the cache at all its file is gone. In both cases the file wins.
+func (c *Cluster) SetStore(s Store) error {
+	stale := []string{}
+	for _, name := range s.Keys() {
+		buf, ok := s.Get(name)
+		if !ok {
+			continue
+		}
+		cl := &xdspb2.Cluster{}
+		if err := proto.Unmarshal(buf, cl); err != nil {
+			return fmt.Errorf("cluster %q: %s", name, err)
+		}
+		if hash := HashFromMetadata(cl); hash != "" && hash != c.hash(name) {
+			log.With("cluster", name).Infof("Not replaying cluster %q from store, its file changed", name)
+			stale = append(stale, name)
+			continue
+		}
+		c.Insert(cl)
+	}
+
+	c.mu.Lock()
+	c.store = s
+	for name, e := range c.c { // journal the clusters that are not in the store yet, or differ from it
+		c.journal(name, e.cluster)
+	}
+	for _, name := range stale {
+		if _, ok := c.c[name]; !ok {
+			c.journal(name, nil)
+		}
+	}
+	c.mu.Unlock()
+
+	c.flush()
+	return nil
+}
+
+// hash returns the hash in the metadata of cluster name, or the empty string if the cluster doesn't exist.
+func (c *Cluster) hash(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.c[name]
+	if !ok {
+		return ""
+	}
+	return HashFromMetadata(e.cluster)
+}
+
+// journal queues cl to be written to the store, if cl is nil the cluster name is deleted from it. The caller must
+// hold c.mu and call flush after releasing it.
+func (c *Cluster) journal(name string, cl *xdspb2.Cluster) {
+	if c.store == nil {
+		return
+	}
+	if cl == nil {
+		c.pending[name] = nil
+		return
+	}
+	b := proto.NewBuffer(nil)
+	b.SetDeterministic(true)
+	if err := b.Marshal(cl); err != nil {
+		log.Warningf("Failed to marshal cluster %q for store: %s", name, err)
+		return
+	}
+	c.pending[name] = b.Bytes()
+}
+
+// flush writes the changes queued by journal to the store. This is done without holding c.mu, as a write to the
+// store can be slow (it syncs to disk). Flushes are serialized by c.jmu, so changes are written in order.
+func (c *Cluster) flush() {
+	c.jmu.Lock()
+	defer c.jmu.Unlock()
+
+	c.mu.Lock()
+	store, pending := c.store, c.pending
+	c.pending = map[string][]byte{}
+	c.mu.Unlock()
+
+	for name, buf := range pending {
+		if buf == nil {
+			if err := store.Delete(name); err != nil {
+				log.Warningf("Failed to delete cluster %q from store: %s", name, err)
+			}
+			continue
+		}
+		if old, ok := store.Get(name); ok && bytes.Equal(old, buf) {
+			continue
+		}
+		if err := store.Put(name, buf); err != nil {
+			log.Warningf("Failed to write cluster %q to store: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	clusterpb3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corepb3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointpb3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerpb3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routepb3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	httppb3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/miekg/xds/pkg/resource"
+)
+
+// resources3 returns the v3 resources of type typeURL. We only store v2 clusters, the v3 resources are translated
+// from the v2 ones. Names and versions are identical to the v2 resources.
+func (c *Cluster) resources3(node *corepb2.Node, typeURL string, names []string) ([]Resource, uint64, error) {
+	resources, version, err := c.Resources(node, resource.V2(typeURL), names)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range resources {
+		data, err := translate(typeURL, resources[i].Any.GetValue())
+		if err != nil {
+			return nil, 0, err
+		}
+		resources[i].Any = &any.Any{TypeUrl: typeURL, Value: data}
+	}
+	return resources, version, nil
+}
+
+// translate translates the marshaled v2 resource in data to a marshaled v3 resource of type typeURL. The v2 and v3
+// messages are wire compatible, but config sources must be told to use the v3 API and embedded type URLs need to
+// be the v3 ones.
+func translate(typeURL string, data []byte) ([]byte, error) {
+	switch typeURL {
+	case resource.ClusterType3:
+		cl := &clusterpb3.Cluster{}
+		if err := proto.Unmarshal(data, cl); err != nil {
+			return nil, err
+		}
+		if eds := cl.GetEdsClusterConfig().GetEdsConfig(); eds != nil {
+			eds.ResourceApiVersion = corepb3.ApiVersion_V3
+		}
+		return MarshalResource(cl)
+
+	case resource.EndpointType3:
+		cla := &endpointpb3.ClusterLoadAssignment{}
+		if err := proto.Unmarshal(data, cla); err != nil {
+			return nil, err
+		}
+		return MarshalResource(cla)
+
+	case resource.ListenerType3:
+		lst := &listenerpb3.Listener{}
+		if err := proto.Unmarshal(data, lst); err != nil {
+			return nil, err
+		}
+		if api := lst.GetApiListener().GetApiListener(); api != nil && api.TypeUrl == resource.HttpConnManagerType {
+			hcm := &httppb3.HttpConnectionManager{}
+			if err := proto.Unmarshal(api.Value, hcm); err != nil {
+				return nil, err
+			}
+			if rds := hcm.GetRds().GetConfigSource(); rds != nil {
+				rds.ResourceApiVersion = corepb3.ApiVersion_V3
+			}
+			hcmdata, err := MarshalResource(hcm)
+			if err != nil {
+				return nil, err
+			}
+			api.TypeUrl = resource.HttpConnManagerType3
+			api.Value = hcmdata
+		}
+		return MarshalResource(lst)
+
+	case resource.RouteConfigType3:
+		routec := &routepb3.RouteConfiguration{}
+		if err := proto.Unmarshal(data, routec); err != nil {
+			return nil, err
+		}
+		return MarshalResource(routec)
+	}
+	return data, nil
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// Validate checks if cl can be served: it must have a name, use EDS and have health checks. It also fixes up the
+// cluster by defaulting the health check durations and thresholds that are left out, pointing EDS to ADS and
+// setting the cluster name in the load assignment.
+func Validate(cl *xdspb2.Cluster) error {
+	name := cl.GetName()
+	if name == "" {
+		return fmt.Errorf("cluster must have a name")
+	}
+	if cl.GetType() != xdspb2.Cluster_EDS {
+		return fmt.Errorf("cluster %q must have discovery type set to EDS", name)
+	}
+	hcs := cl.GetHealthChecks()
+	if len(hcs) == 0 {
+		return fmt.Errorf("cluster %q must have health checks", name)
+	}
+	for _, hc := range hcs {
+		setDurationIfNil(&hc.Timeout, 5*time.Second, fmt.Sprintf("Cluster %q, setting %s to", name, "Timeout"))
+		setDurationIfNil(&hc.Interval, 10*time.Second, fmt.Sprintf("Cluster %q, setting %s to", name, "Interval"))
+		setDurationIfNil(&hc.InitialJitter, 2*time.Second, fmt.Sprintf("Cluster %q, setting %s to", name, "InitialJitter"))
+		setDurationIfNil(&hc.IntervalJitter, 1*time.Second, fmt.Sprintf("Cluster %q, setting %s to", name, "IntervalJitter"))
+		setUint32IfNil(&hc.UnhealthyThreshold, 3, fmt.Sprintf("Cluster %q, setting %s to", name, "UnhealthyThreshold"))
+		setUint32IfNil(&hc.HealthyThreshold, 2, fmt.Sprintf("Cluster %q, setting %s to", name, "HealthyThreshold"))
+	}
+	cl.EdsClusterConfig = &xdspb2.Cluster_EdsClusterConfig{
+		EdsConfig: &corepb2.ConfigSource{ConfigSourceSpecifier: &corepb2.ConfigSource_Ads{Ads: &corepb2.AggregatedConfigSource{}}},
+	}
+
+	// If the endpoints cluster name if not set, set it to the cluster name
+	if cl.LoadAssignment == nil {
+		cl.LoadAssignment = &xdspb2.ClusterLoadAssignment{}
+	}
+	if cl.LoadAssignment.ClusterName != name {
+		cl.LoadAssignment.ClusterName = name
+	}
+	return nil
+}
+
+func setDurationIfNil(a **duration.Duration, v time.Duration, msg string) {
+	if *a != nil {
+		return
+	}
+	*a = &duration.Duration{Seconds: int64(v / time.Second)} // skip Nanos
+	log.Debugf("%s %s", msg, v)
+}
+
+func setUint32IfNil(a **wrappers.UInt32Value, v uint32, msg string) {
+	if *a != nil {
+		return
+	}
+	*a = &wrappers.UInt32Value{Value: v}
+	log.Debugf("%s %d", msg, v)
+}
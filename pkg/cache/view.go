@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"path"
+	"reflect"
+	"sort"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// View selects the clusters and endpoints a node gets to see. Views are checked in order of their name, the
+// first one that matches the node is used. If no view matches, the node sees everything.
+type View struct {
+	Name  string `json:"-"`
+	Match Match  `json:"match"`
+	// Clusters holds the names (globs are allowed) of the clusters visible to the node. If empty all
+	// clusters are visible.
+	Clusters []string `json:"clusters,omitempty"`
+	// MetadataKey, if set, only makes clusters visible that list the node's id, cluster or zone under
+	// this key in their "view" filter metadata.
+	MetadataKey string `json:"metadata_key,omitempty"`
+	// Weights overrides the locality weights: cluster name (globs are allowed) -> locality -> weight. The
+	// locality is written as "region/zone/subzone", see Locality.
+	Weights map[string]map[string]uint32 `json:"weights,omitempty"`
+}
+
+// Match holds the node properties a view applies to. Empty fields match anything, others are matched as
+// globs.
+type Match struct {
+	ID       string            `json:"id,omitempty"`
+	Cluster  string            `json:"cluster,omitempty"`
+	Region   string            `json:"region,omitempty"`
+	Zone     string            `json:"zone,omitempty"`
+	SubZone  string            `json:"sub_zone,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"` // string fields in the node metadata
+}
+
+// SetViews replaces the views in the cache. Because a view change can change what every node sees, all
+// clusters and endpoints get a new version. If the views are identical to the current ones this is a noop.
+func (c *Cluster) SetViews(views []*View) {
+	c.update(nil, nil, views, true, nil)
+}
+
+// setViews replaces the views and gives all clusters and endpoints version, if the views changed. It returns
+// true if they did. The caller must hold c.mu.
+func (c *Cluster) setViews(views []*View, version uint64) bool {
+	views = append([]*View(nil), views...)
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+
+	if reflect.DeepEqual(c.views, views) {
+		return false
+	}
+	c.views = views
+	c.removed = version // clusters may have disappeared for some nodes.
+	for _, e := range c.c {
+		e.version = version
+		e.eversion = version
+	}
+	return true
+}
+
+// Views returns the views in the cache.
+func (c *Cluster) Views() []*View {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.views
+}
+
+// ViewFor returns the view that applies to node, or nil if there is none.
+func (c *Cluster) ViewFor(node *corepb2.Node) *View {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.viewFor(node)
+}
+
+// viewFor is ViewFor, the caller must hold c.mu.
+func (c *Cluster) viewFor(node *corepb2.Node) *View {
+	for _, v := range c.views {
+		if v.Match.matches(node) {
+			return v
+		}
+	}
+	return nil
+}
+
+func (m Match) matches(node *corepb2.Node) bool {
+	if !glob(m.ID, node.GetId()) || !glob(m.Cluster, node.GetCluster()) {
+		return false
+	}
+	l := node.GetLocality()
+	if !glob(m.Region, l.GetRegion()) || !glob(m.Zone, l.GetZone()) || !glob(m.SubZone, l.GetSubZone()) {
+		return false
+	}
+	fields := node.GetMetadata().GetFields()
+	for k, v := range m.Metadata {
+		if !glob(v, fields[k].GetStringValue()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Visible returns true if the cluster cl is visible in the view. A nil view sees all clusters.
+func (v *View) Visible(node *corepb2.Node, cl *xdspb2.Cluster) bool {
+	if v == nil {
+		return true
+	}
+	if len(v.Clusters) > 0 {
+		found := false
+		for _, name := range v.Clusters {
+			if glob(name, cl.GetName()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if v.MetadataKey == "" {
+		return true
+	}
+
+	labels := cl.GetMetadata().GetFilterMetadata()[ViewKind].GetFields()[v.MetadataKey]
+	values := []string{labels.GetStringValue()}
+	for _, l := range labels.GetListValue().GetValues() {
+		values = append(values, l.GetStringValue())
+	}
+	for _, l := range values {
+		if l == "" {
+			continue
+		}
+		if l == node.GetId() || l == node.GetCluster() || l == node.GetLocality().GetZone() {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply applies the view's locality weight overrides to the endpoints of cluster.
+func (v *View) Apply(cluster string, cla *xdspb2.ClusterLoadAssignment) {
+	if v == nil {
+		return
+	}
+	for name, weights := range v.Weights {
+		if !glob(name, cluster) {
+			continue
+		}
+		for _, ep := range cla.GetEndpoints() {
+			if w, ok := weights[Locality(ep.GetLocality())]; ok {
+				ep.LoadBalancingWeight = &wrappers.UInt32Value{Value: w}
+			}
+		}
+	}
+}
+
+// glob returns true if s matches pattern. An empty pattern matches anything.
+func glob(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
@@ -0,0 +1,163 @@
+package healthcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// check runs the health check hc against addr, with the given timeout. The host is used as the HTTP Host header and
+// gRPC authority if the health check itself doesn't specify one. A nil error means the endpoint is healthy.
+func check(hc *corepb2.HealthCheck, addr, host string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch x := hc.HealthChecker.(type) {
+	case *corepb2.HealthCheck_TcpHealthCheck_:
+		return checkTCP(ctx, x.TcpHealthCheck, addr)
+	case *corepb2.HealthCheck_HttpHealthCheck_:
+		return checkHTTP(ctx, x.HttpHealthCheck, addr, host)
+	case *corepb2.HealthCheck_GrpcHealthCheck_:
+		return checkGRPC(ctx, x.GrpcHealthCheck, addr, host)
+	}
+	return errUnsupported
+}
+
+var errUnsupported = fmt.Errorf("unsupported health check")
+
+// checkTCP connects to addr and, if configured, sends the payload and checks if all receive payloads are
+// found in the response.
+func checkTCP(ctx context.Context, hc *corepb2.HealthCheck_TcpHealthCheck, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if hc.GetSend() != nil {
+		send, err := payload(hc.GetSend())
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(send); err != nil {
+			return err
+		}
+	}
+	if len(hc.GetReceive()) == 0 {
+		return nil
+	}
+
+	want := [][]byte{}
+	size := 0
+	for _, r := range hc.GetReceive() {
+		p, err := payload(r)
+		if err != nil {
+			return err
+		}
+		want = append(want, p)
+		size += len(p)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	for _, w := range want {
+		if !bytes.Contains(buf, w) {
+			return fmt.Errorf("payload %x not found in response", w)
+		}
+	}
+	return nil
+}
+
+// checkHTTP does a GET on the health check's path and checks the status code. If no expected statuses are given, only
+// 200 is considered healthy.
+func checkHTTP(ctx context.Context, hc *corepb2.HealthCheck_HttpHealthCheck, addr, host string) error {
+	req, err := http.NewRequest("GET", "http://"+addr+hc.GetPath(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Host = host
+	if hc.GetHost() != "" {
+		req.Host = hc.GetHost()
+	}
+	req.Header.Set("User-Agent", "xds-healthchecker")
+	for _, h := range hc.GetRequestHeadersToAdd() {
+		if h.GetAppend().GetValue() {
+			req.Header.Add(h.GetHeader().GetKey(), h.GetHeader().GetValue())
+			continue
+		}
+		req.Header.Set(h.GetHeader().GetKey(), h.GetHeader().GetValue())
+	}
+	for _, h := range hc.GetRequestHeadersToRemove() {
+		req.Header.Del(h)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if len(hc.GetExpectedStatuses()) == 0 {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return nil
+	}
+	for _, r := range hc.GetExpectedStatuses() {
+		// start is inclusive, end is exclusive.
+		if int64(resp.StatusCode) >= r.GetStart() && int64(resp.StatusCode) < r.GetEnd() {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}
+
+// checkGRPC uses the standard gRPC health checking protocol.
+func checkGRPC(ctx context.Context, hc *corepb2.HealthCheck_GrpcHealthCheck, addr, host string) error {
+	authority := host
+	if hc.GetAuthority() != "" {
+		authority = hc.GetAuthority()
+	}
+	cc, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithAuthority(authority))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: hc.GetServiceName()})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service status is %s", resp.GetStatus())
+	}
+	return nil
+}
+
+// payload returns the bytes of the payload p.
+func payload(p *corepb2.HealthCheck_Payload) ([]byte, error) {
+	switch x := p.GetPayload().(type) {
+	case *corepb2.HealthCheck_Payload_Text:
+		return hex.DecodeString(x.Text)
+	case *corepb2.HealthCheck_Payload_Binary:
+		return x.Binary, nil
+	}
+	return nil, nil
+}
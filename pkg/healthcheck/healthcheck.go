@@ -0,0 +1,272 @@
+// Package healthcheck implements active health checking of the endpoints in the cache. It runs the health checks
+// as defined in the clusters (TCP, HTTP and gRPC) against each endpoint and sets the resulting health in the cache.
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	edspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/miekg/xds/pkg/audit"
+	"github.com/miekg/xds/pkg/cache"
+	clog "github.com/miekg/xds/pkg/log"
+)
+
+var log = clog.New(clog.Health)
+
+// Checker runs the health checks for all endpoints of all clusters in the cache.
+type Checker struct {
+	cache   cache.Cache
+	audit   *audit.Log
+	targets map[string]*target
+}
+
+// New returns a new Checker that checks the endpoints in c. Health changes are recorded in a, if not nil.
+func New(c cache.Cache, a *audit.Log) *Checker {
+	return &Checker{cache: c, audit: a, targets: map[string]*target{}}
+}
+
+// Run runs the health checks until ctx is canceled. Every time the cache changes the set of endpoints and health
+// checks is updated.
+func (c *Checker) Run(ctx context.Context) {
+	watch, cancel := c.cache.Watch()
+	defer cancel()
+
+	c.reconcile(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			for _, t := range c.targets {
+				t.cancel()
+			}
+			return
+		case <-watch:
+			c.reconcile(ctx)
+		}
+	}
+}
+
+// reconcile starts health checking new endpoints and stops checking the ones that are gone. Endpoints whose health
+// checks have changed are restarted.
+func (c *Checker) reconcile(ctx context.Context) {
+	seen := map[string]struct{}{}
+	for _, name := range c.cache.All() {
+		cl, _ := c.cache.Retrieve(name)
+		if cl == nil {
+			continue
+		}
+		checks := []*corepb2.HealthCheck{}
+		for _, hc := range cl.GetHealthChecks() {
+			switch hc.HealthChecker.(type) {
+			case *corepb2.HealthCheck_TcpHealthCheck_, *corepb2.HealthCheck_HttpHealthCheck_, *corepb2.HealthCheck_GrpcHealthCheck_:
+				checks = append(checks, hc)
+			default:
+				log.Warningf("Cluster %q has an unsupported health check: %T", name, hc.HealthChecker)
+			}
+		}
+		if len(checks) == 0 {
+			continue
+		}
+
+		for _, ep := range cl.GetLoadAssignment().GetEndpoints() {
+			for _, lb := range ep.GetLbEndpoints() {
+				e := lb.GetEndpoint()
+				if e.GetAddress().GetSocketAddress() == nil {
+					continue
+				}
+				key := targetKey(name, e, checks)
+				seen[key] = struct{}{}
+				if _, ok := c.targets[key]; ok {
+					continue
+				}
+				t := newTarget(c.cache, c.audit, name, e, checks)
+				c.targets[key] = t
+				t.start(ctx)
+			}
+		}
+	}
+
+	for key, t := range c.targets {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		t.cancel()
+		delete(c.targets, key)
+	}
+}
+
+// targetKey returns a key that uniquely identifies the endpoint e in cluster together with its health checks.
+func targetKey(cluster string, e *edspb2.Endpoint, checks []*corepb2.HealthCheck) string {
+	parts := []string{cluster, e.GetAddress().GetSocketAddress().String(), strconv.Itoa(int(e.GetHealthCheckConfig().GetPortValue()))}
+	for _, hc := range checks {
+		parts = append(parts, proto.CompactTextString(hc))
+	}
+	return strings.Join(parts, "/")
+}
+
+// state is the state of a single health check for a target.
+type state int
+
+const (
+	unknown state = iota
+	healthy
+	unhealthy
+)
+
+// target is an endpoint in a cluster that we health check. Each health check runs independently, the endpoint is
+// healthy if all health checks are.
+type target struct {
+	cache    cache.Cache
+	audit    *audit.Log
+	cluster  string
+	endpoint *edspb2.Endpoint
+	checks   []*corepb2.HealthCheck
+	cancel   context.CancelFunc
+
+	mu     sync.Mutex
+	states []state // state per health check
+
+	reportMu sync.Mutex           // serializes the reports to the cache
+	reported corepb2.HealthStatus // last health successfully reported to the cache
+}
+
+func newTarget(c cache.Cache, a *audit.Log, cluster string, e *edspb2.Endpoint, checks []*corepb2.HealthCheck) *target {
+	return &target{cache: c, audit: a, cluster: cluster, endpoint: e, checks: checks, states: make([]state, len(checks))}
+}
+
+func (t *target) start(ctx context.Context) {
+	ctx, t.cancel = context.WithCancel(ctx)
+	for i := range t.checks {
+		go t.run(ctx, i)
+	}
+}
+
+// run runs health check i until ctx is canceled.
+func (t *target) run(ctx context.Context, i int) {
+	hc := t.checks[i]
+	addr := t.addr(hc)
+	timeout := durationOr(hc.GetTimeout(), 5*time.Second)
+	interval := durationOr(hc.GetInterval(), 10*time.Second)
+	healthyThreshold := int(hc.GetHealthyThreshold().GetValue())
+	unhealthyThreshold := int(hc.GetUnhealthyThreshold().GetValue())
+
+	wait := jitter(durationOr(hc.GetInitialJitter(), 0))
+	successes, failures := 0, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		err := check(hc, addr, t.cluster, timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			successes++
+			failures = 0
+		} else {
+			failures++
+			successes = 0
+			log.With("cluster", t.cluster).Debugf("Health check for %s in cluster %q failed: %s", addr, t.cluster, err)
+		}
+
+		t.mu.Lock()
+		// The first result decides the state, after that the thresholds need to be crossed.
+		switch {
+		case err == nil && (t.states[i] == unknown || successes >= healthyThreshold):
+			t.states[i] = healthy
+		case err != nil && (t.states[i] == unknown || failures >= unhealthyThreshold):
+			t.states[i] = unhealthy
+		}
+		t.mu.Unlock()
+		t.report()
+
+		wait = interval + jitter(durationOr(hc.GetIntervalJitter(), 0))
+		if p := hc.GetIntervalJitterPercent(); p > 0 {
+			wait += jitter(interval * time.Duration(p) / 100)
+		}
+	}
+}
+
+// report sets the health of the endpoint in the cache if it has changed. If that fails it is tried again on the next
+// report.
+func (t *target) report() {
+	t.reportMu.Lock()
+	defer t.reportMu.Unlock()
+
+	t.mu.Lock()
+	status := corepb2.HealthStatus_HEALTHY
+	for _, s := range t.states {
+		if s == unknown {
+			t.mu.Unlock()
+			return
+		}
+		if s == unhealthy {
+			status = corepb2.HealthStatus_UNHEALTHY
+		}
+	}
+	t.mu.Unlock()
+	if status == t.reported {
+		return
+	}
+
+	log.With("cluster", t.cluster).Infof("Endpoint %s in cluster %q is %s", t.endpoint.GetAddress().GetSocketAddress().GetAddress(), t.cluster, status)
+	req := &healthpb2.EndpointHealthResponse{
+		EndpointsHealth: []*healthpb2.EndpointHealth{{Endpoint: t.endpoint, HealthStatus: status}},
+	}
+	changes := audit.Changes{}
+	_, err := t.cache.ReportHealth(t.cluster, req, changes.Observe)
+	t.audit.Record(audit.Caller{Identity: "healthcheck"}, changes...)
+	if err != nil {
+		log.Warningf("Failed to set health for %s in cluster %q: %s", t.endpoint.GetAddress().GetSocketAddress().GetAddress(), t.cluster, err)
+		return
+	}
+	t.reported = status
+}
+
+// addr returns the address to health check. The port can be overridden by the endpoint's health check config
+// or the health check's alt_port.
+func (t *target) addr(hc *corepb2.HealthCheck) string {
+	sa := t.endpoint.GetAddress().GetSocketAddress()
+	port := sa.GetPortValue()
+	if p := hc.GetAltPort().GetValue(); p != 0 {
+		port = p
+	}
+	if p := t.endpoint.GetHealthCheckConfig().GetPortValue(); p != 0 {
+		port = p
+	}
+	return net.JoinHostPort(sa.GetAddress(), fmt.Sprintf("%d", port))
+}
+
+// durationOr returns d as a time.Duration, or def if d is not set or invalid.
+func durationOr(d *duration.Duration, def time.Duration) time.Duration {
+	if d == nil {
+		return def
+	}
+	x, err := ptypes.Duration(d)
+	if err != nil {
+		return def
+	}
+	return x
+}
+
+// jitter returns a random duration in [0, d).
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,101 @@
+package healthcheck
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	edspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/miekg/xds/pkg/cache"
+)
+
+func newEndpoint(t *testing.T, addr string) *edspb2.LbEndpoint {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return &edspb2.LbEndpoint{HostIdentifier: &edspb2.LbEndpoint_Endpoint{Endpoint: &edspb2.Endpoint{
+		Address: &corepb2.Address{Address: &corepb2.Address_SocketAddress{
+			SocketAddress: &corepb2.SocketAddress{Address: host, PortSpecifier: &corepb2.SocketAddress_PortValue{PortValue: uint32(p)}},
+		}},
+	}}}
+}
+
+func TestChecker(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	// a listener that is closed immediately, so TCP checks fail.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	c := cache.New()
+	interval := &duration.Duration{Nanos: int32(10 * time.Millisecond)}
+	c.Insert(&xdspb2.Cluster{
+		Name: "http",
+		HealthChecks: []*corepb2.HealthCheck{{
+			Interval:      interval,
+			Timeout:       &duration.Duration{Seconds: 1},
+			HealthChecker: &corepb2.HealthCheck_HttpHealthCheck_{HttpHealthCheck: &corepb2.HealthCheck_HttpHealthCheck{Path: "/"}},
+		}},
+		LoadAssignment: &xdspb2.ClusterLoadAssignment{
+			ClusterName: "http",
+			Endpoints: []*edspb2.LocalityLbEndpoints{{
+				LbEndpoints: []*edspb2.LbEndpoint{newEndpoint(t, up.Listener.Addr().String()), newEndpoint(t, down.Listener.Addr().String())},
+			}},
+		},
+	})
+	c.Insert(&xdspb2.Cluster{
+		Name: "tcp",
+		HealthChecks: []*corepb2.HealthCheck{{
+			Interval:      interval,
+			Timeout:       &duration.Duration{Seconds: 1},
+			HealthChecker: &corepb2.HealthCheck_TcpHealthCheck_{TcpHealthCheck: &corepb2.HealthCheck_TcpHealthCheck{}},
+		}},
+		LoadAssignment: &xdspb2.ClusterLoadAssignment{
+			ClusterName: "tcp",
+			Endpoints: []*edspb2.LocalityLbEndpoints{{
+				LbEndpoints: []*edspb2.LbEndpoint{newEndpoint(t, up.Listener.Addr().String()), newEndpoint(t, closed)},
+			}},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go New(c, nil).Run(ctx)
+
+	want := map[string][]corepb2.HealthStatus{
+		"http": {corepb2.HealthStatus_HEALTHY, corepb2.HealthStatus_UNHEALTHY},
+		"tcp":  {corepb2.HealthStatus_HEALTHY, corepb2.HealthStatus_UNHEALTHY},
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for name, health := range want {
+		for {
+			cl, _ := c.Retrieve(name)
+			lbs := cl.GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints()
+			if lbs[0].HealthStatus == health[0] && lbs[1].HealthStatus == health[1] {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected health %v for cluster %q, got %s and %s", health, name, lbs[0].HealthStatus, lbs[1].HealthStatus)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
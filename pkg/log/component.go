@@ -0,0 +1,208 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	golog "log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Components that log with their own level.
+const (
+	Server = "server"      // the xDS server.
+	Cache  = "cache"       // the cache.
+	Config = "config"      // loading (and writing back) the configuration.
+	LRS    = "lrs"         // load reporting.
+	HDS    = "hds"         // health discovery.
+	Health = "healthcheck" // the health checker.
+	Audit  = "audit"       // the audit log.
+)
+
+// Components holds all components.
+var Components = []string{Server, Cache, Config, LRS, HDS, Health, Audit}
+
+// Logger logs for a component, with optional key/value fields.
+type Logger struct {
+	component string
+	fields    []interface{} // key, value, key, value, ...
+}
+
+// New returns a logger for component.
+func New(component string) Logger { return Logger{component: component} }
+
+// With returns a logger that adds the key/value pairs in kv to each log.
+func (l Logger) With(kv ...interface{}) Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return Logger{component: l.component, fields: fields}
+}
+
+// Debug logs on the debug level.
+func (l Logger) Debug(v ...interface{}) { l.log(debug, fmt.Sprint(v...)) }
+
+// Debugf logs on the debug level.
+func (l Logger) Debugf(format string, v ...interface{}) { l.logf(debug, format, v...) }
+
+// Info logs on the info level.
+func (l Logger) Info(v ...interface{}) { l.log(info, fmt.Sprint(v...)) }
+
+// Infof logs on the info level.
+func (l Logger) Infof(format string, v ...interface{}) { l.logf(info, format, v...) }
+
+// Warning logs on the warning level.
+func (l Logger) Warning(v ...interface{}) { l.log(warning, fmt.Sprint(v...)) }
+
+// Warningf logs on the warning level.
+func (l Logger) Warningf(format string, v ...interface{}) { l.logf(warning, format, v...) }
+
+// Error logs on the error level.
+func (l Logger) Error(v ...interface{}) { l.log(err, fmt.Sprint(v...)) }
+
+// Errorf logs on the error level.
+func (l Logger) Errorf(format string, v ...interface{}) { l.logf(err, format, v...) }
+
+func (l Logger) log(level, msg string) {
+	if enabled(l.component, level) {
+		output(level, l.component, l.fields, msg)
+	}
+}
+
+func (l Logger) logf(level, format string, v ...interface{}) {
+	if enabled(l.component, level) {
+		output(level, l.component, l.fields, fmt.Sprintf(format, v...))
+	}
+}
+
+// levels holds the level per component, the empty component is the default.
+var levels = struct {
+	sync.RWMutex
+	m    map[string]string
+	json bool
+}{m: map[string]string{"": "info"}}
+
+// rank orders the levels, a log is output if its rank is at least the rank of the level set.
+var rank = map[string]int{"debug": 0, "info": 1, "warning": 2, "error": 3}
+
+// SetLevel sets the level of component to level, which is one of "debug", "info", "warning" or "error". The
+// empty component sets the default level, which is used by components that have no level of their own. If
+// level is empty the component uses the default level again.
+func SetLevel(component, level string) error {
+	if component != "" && !known(component) {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	levels.Lock()
+	defer levels.Unlock()
+	if level == "" && component != "" {
+		delete(levels.m, component)
+		return nil
+	}
+	if _, ok := rank[level]; !ok {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	levels.m[component] = level
+	return nil
+}
+
+func known(component string) bool {
+	for _, c := range Components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseLevels parses levels in the form "level" or "component=level,component=level" and sets them.
+func ParseLevels(s string) error {
+	for _, l := range strings.Split(s, ",") {
+		if l == "" {
+			continue
+		}
+		component, level := "", l
+		if i := strings.Index(l, "="); i >= 0 {
+			component, level = l[:i], l[i+1:]
+		}
+		if err := SetLevel(component, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Levels returns the levels that are set, the empty component holds the default level.
+func Levels() map[string]string {
+	levels.RLock()
+	defer levels.RUnlock()
+	m := make(map[string]string, len(levels.m))
+	for k, v := range levels.m {
+		m[k] = v
+	}
+	return m
+}
+
+// SetJSON enables (or disables) writing logs as JSON objects.
+func SetJSON(on bool) {
+	levels.Lock()
+	levels.json = on
+	levels.Unlock()
+}
+
+// enabled returns true if a log on level should be output for component. Debug logs are always output if D is
+// set.
+func enabled(component, level string) bool {
+	name := strings.ToLower(strings.Trim(level, "[] "))
+	if name == "debug" && D.Value() {
+		return true
+	}
+	levels.RLock()
+	defer levels.RUnlock()
+	l, ok := levels.m[component]
+	if !ok {
+		l = levels.m[""]
+	}
+	return rank[name] >= rank[l]
+}
+
+// output writes msg on level for component with fields.
+func output(level, component string, fields []interface{}, msg string) {
+	levels.RLock()
+	asJSON := levels.json
+	levels.RUnlock()
+
+	name := strings.ToLower(strings.Trim(level, "[] "))
+	if asJSON {
+		m := map[string]interface{}{"time": time.Now().UTC().Format(time.RFC3339Nano), "level": name, "msg": msg}
+		if component != "" {
+			m["component"] = component
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			m[fmt.Sprint(fields[i])] = fields[i+1]
+		}
+		buf, err := json.Marshal(m)
+		if err != nil {
+			buf = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error()))
+		}
+		golog.Print(string(buf))
+		return
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(level)
+	if component != "" {
+		b.WriteString(component + ": ")
+	}
+	b.WriteString(msg)
+	kv := make([]string, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		kv = append(kv, fmt.Sprintf("%v=%q", fields[i], fmt.Sprint(fields[i+1])))
+	}
+	sort.Strings(kv)
+	for _, f := range kv {
+		b.WriteString(" " + f)
+	}
+	golog.Print(b.String())
+}
@@ -5,6 +5,10 @@
 // log.Info("this is some logging"), will log on the Info level.
 //
 // log.Debug("this is debug output"), will log in the Debug level, etc.
+//
+// Components (see New) log with their own level, set with SetLevel, and can
+// add key/value fields to their logs. With SetJSON each log is written as a
+// JSON object instead.
 package log
 
 import (
@@ -52,43 +56,59 @@ func (d *d) Value() bool {
 
 // logf calls log.Printf prefixed with level.
 func logf(level, format string, v ...interface{}) {
-	golog.Print(level, fmt.Sprintf(format, v...))
+	output(level, "", nil, fmt.Sprintf(format, v...))
 }
 
 // log calls log.Print prefixed with level.
 func log(level string, v ...interface{}) {
-	golog.Print(level, fmt.Sprint(v...))
+	output(level, "", nil, fmt.Sprint(v...))
 }
 
 // Debug is equivalent to log.Print(), but prefixed with "[DEBUG] ". It only outputs something
-// if D is true.
+// if D is true or the default level is debug.
 func Debug(v ...interface{}) {
-	if !D.Value() {
+	if !enabled("", debug) {
 		return
 	}
 	log(debug, v...)
 }
 
 // Debugf is equivalent to log.Printf(), but prefixed with "[DEBUG] ". It only outputs something
-// if D is true.
+// if D is true or the default level is debug.
 func Debugf(format string, v ...interface{}) {
-	if !D.Value() {
+	if !enabled("", debug) {
 		return
 	}
 	logf(debug, format, v...)
 }
 
 // Info is equivalent to log.Print, but prefixed with "[INFO] ".
-func Info(v ...interface{}) { log(info, v...) }
+func Info(v ...interface{}) {
+	if enabled("", info) {
+		log(info, v...)
+	}
+}
 
 // Infof is equivalent to log.Printf, but prefixed with "[INFO] ".
-func Infof(format string, v ...interface{}) { logf(info, format, v...) }
+func Infof(format string, v ...interface{}) {
+	if enabled("", info) {
+		logf(info, format, v...)
+	}
+}
 
 // Warning is equivalent to log.Print, but prefixed with "[WARNING] ".
-func Warning(v ...interface{}) { log(warning, v...) }
+func Warning(v ...interface{}) {
+	if enabled("", warning) {
+		log(warning, v...)
+	}
+}
 
 // Warningf is equivalent to log.Printf, but prefixed with "[WARNING] ".
-func Warningf(format string, v ...interface{}) { logf(warning, format, v...) }
+func Warningf(format string, v ...interface{}) {
+	if enabled("", warning) {
+		logf(warning, format, v...)
+	}
+}
 
 // Error is equivalent to log.Print, but prefixed with "[ERROR] ".
 func Error(v ...interface{}) { log(err, v...) }
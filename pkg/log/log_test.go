@@ -2,6 +2,7 @@ package log
 
 import (
 	"bytes"
+	"encoding/json"
 	golog "log"
 	"strings"
 	"testing"
@@ -70,3 +71,67 @@ func TestLevels(t *testing.T) {
 		t.Errorf("Expected log to be %s, got %s", err+ts, x)
 	}
 }
+
+func TestComponent(t *testing.T) {
+	var f bytes.Buffer
+	golog.SetOutput(&f)
+	defer SetLevel(Server, "")
+	D.Clear()
+
+	l := New(Server).With("node", "n1")
+	l.Debug("debug")
+	if x := f.String(); x != "" {
+		t.Errorf("Expected no debug logs, got %s", x)
+	}
+
+	if err := SetLevel(Server, "debug"); err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("debug")
+	if x := f.String(); !strings.Contains(x, debug+"server: debug node=\"n1\"") {
+		t.Errorf("Expected debug log with fields, got %s", x)
+	}
+	f.Reset()
+
+	// other components are not affected.
+	New(Cache).Debug("debug")
+	if x := f.String(); x != "" {
+		t.Errorf("Expected no debug logs, got %s", x)
+	}
+
+	if err := ParseLevels("server=error"); err != nil {
+		t.Fatal(err)
+	}
+	l.Warning("warning")
+	if x := f.String(); x != "" {
+		t.Errorf("Expected no warning logs, got %s", x)
+	}
+	if err := ParseLevels("bla=error"); err == nil {
+		t.Errorf("Expected error for unknown component")
+	}
+	if err := ParseLevels("server=bla"); err == nil {
+		t.Errorf("Expected error for unknown level")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var f bytes.Buffer
+	golog.SetOutput(&f)
+	flags := golog.Flags()
+	golog.SetFlags(0)
+	defer golog.SetFlags(flags)
+	SetJSON(true)
+	defer SetJSON(false)
+
+	New(Cache).With("cluster", "xds", "version", 4).Infof("%s", "test")
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(f.Bytes(), &m); err != nil {
+		t.Fatalf("Expected JSON log, got %s: %s", f.String(), err)
+	}
+	expect := map[string]interface{}{"level": "info", "component": "cache", "msg": "test", "cluster": "xds", "version": 4.0}
+	for k, v := range expect {
+		if m[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+}
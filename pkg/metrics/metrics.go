@@ -0,0 +1,159 @@
+// Package metrics implements counters and gauges that are served in the Prometheus text format. Metrics are
+// registered in a global registry when they are created, Handler serves all of them.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Namespace is prefixed to the name of every metric.
+const Namespace = "xds"
+
+var registry = struct {
+	sync.Mutex
+	metrics []*metric
+}{}
+
+// metric is a counter or a gauge, with zero or more labels.
+type metric struct {
+	name   string
+	help   string
+	typ    string // "counter" or "gauge"
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // label values joined with \xff -> value
+}
+
+func newMetric(typ, subsystem, name, help string, labels []string) *metric {
+	m := &metric{
+		name:   Namespace + "_" + subsystem + "_" + name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: map[string]float64{},
+	}
+	registry.Lock()
+	registry.metrics = append(registry.metrics, m)
+	registry.Unlock()
+	return m
+}
+
+func (m *metric) key(values []string) string {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (m *metric) add(v float64, values []string) {
+	k := m.key(values)
+	m.mu.Lock()
+	m.values[k] += v
+	m.mu.Unlock()
+}
+
+func (m *metric) set(v float64, values []string) {
+	k := m.key(values)
+	m.mu.Lock()
+	m.values[k] = v
+	m.mu.Unlock()
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ m *metric }
+
+// NewCounter returns a new counter with the name "xds_<subsystem>_<name>" and the labels.
+func NewCounter(subsystem, name, help string, labels ...string) *Counter {
+	return &Counter{newMetric("counter", subsystem, name, help, labels)}
+}
+
+// Inc increments the counter with the label values by one.
+func (c *Counter) Inc(values ...string) { c.m.add(1, values) }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ m *metric }
+
+// NewGauge returns a new gauge with the name "xds_<subsystem>_<name>" and the labels.
+func NewGauge(subsystem, name, help string, labels ...string) *Gauge {
+	return &Gauge{newMetric("gauge", subsystem, name, help, labels)}
+}
+
+// Set sets the gauge with the label values to v.
+func (g *Gauge) Set(v float64, values ...string) { g.m.set(v, values) }
+
+// Inc increments the gauge with the label values by one.
+func (g *Gauge) Inc(values ...string) { g.m.add(1, values) }
+
+// Dec decrements the gauge with the label values by one. If it reaches zero the label values are removed.
+func (g *Gauge) Dec(values ...string) {
+	k := g.m.key(values)
+	g.m.mu.Lock()
+	g.m.values[k]--
+	if g.m.values[k] == 0 {
+		delete(g.m.values, k)
+	}
+	g.m.mu.Unlock()
+}
+
+// Reset removes all label values from the gauge.
+func (g *Gauge) Reset() {
+	g.m.mu.Lock()
+	g.m.values = map[string]float64{}
+	g.m.mu.Unlock()
+}
+
+// Handler returns a handler that serves all metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		Write(w)
+	})
+}
+
+// Write writes all metrics in the Prometheus text format to w.
+func Write(w io.Writer) {
+	registry.Lock()
+	metrics := append([]*metric(nil), registry.metrics...)
+	registry.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	for _, m := range metrics {
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.values))
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		if len(m.labels) == 0 && len(keys) == 0 {
+			fmt.Fprintf(w, "%s 0\n", m.name)
+		}
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelString(k), strconv.FormatFloat(m.values[k], 'g', -1, 64))
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *metric) labelString(key string) string {
+	if len(m.labels) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, len(m.labels))
+	for i, l := range m.labels {
+		pairs[i] = l + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes label values as the text format wants: only backslash, double quote and newline are
+// escaped, everything else (i.e. UTF-8) is written as is.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
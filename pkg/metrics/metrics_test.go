@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test", "requests_total", "Requests.", "type")
+	c.Inc("a")
+	c.Inc("a")
+	c.Inc("b")
+	g := NewGauge("test", "streams", "Streams.", "node")
+	g.Inc("n1")
+	g.Inc("n2")
+	g.Dec("n2")
+
+	buf := &bytes.Buffer{}
+	Write(buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE xds_test_requests_total counter\n",
+		`xds_test_requests_total{type="a"} 2` + "\n",
+		`xds_test_requests_total{type="b"} 1` + "\n",
+		`xds_test_streams{node="n1"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `node="n2"`) {
+		t.Errorf("Expected gauge at zero to be removed, got:\n%s", out)
+	}
+}
+
+func TestLabelEscape(t *testing.T) {
+	c := NewCounter("test", "escaped_total", "Escaped.", "node")
+	c.Inc("a\\b\"c\nd\té")
+
+	buf := &bytes.Buffer{}
+	Write(buf)
+	want := `xds_test_escaped_total{node="a\\b\"c\nd` + "\té" + `"} 1` + "\n"
+	if out := buf.String(); !strings.Contains(out, want) {
+		t.Errorf("Expected %q in output, got:\n%s", want, out)
+	}
+}
@@ -27,3 +27,45 @@ const (
 	// AnyType is used only by ADS.
 	AnyType = ""
 )
+
+// Resource types in xDS v3.
+const (
+	ClusterType3     = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+	EndpointType3    = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+	ListenerType3    = "type.googleapis.com/envoy.config.listener.v3.Listener"
+	RouteConfigType3 = "type.googleapis.com/envoy.config.route.v3.RouteConfiguration"
+
+	HttpConnManagerType3 = "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager"
+)
+
+var v3Tov2 = map[string]string{
+	ClusterType3:         ClusterType,
+	EndpointType3:        EndpointType,
+	ListenerType3:        ListenerType,
+	RouteConfigType3:     RouteConfigType,
+	HttpConnManagerType3: HttpConnManagerType,
+}
+
+// V2 returns the v2 type for typeURL. If typeURL isn't a v3 type it is returned as is.
+func V2(typeURL string) string {
+	if t, ok := v3Tov2[typeURL]; ok {
+		return t
+	}
+	return typeURL
+}
+
+// V3 returns the v3 type for typeURL. If typeURL isn't a v2 type it is returned as is.
+func V3(typeURL string) string {
+	for t3, t2 := range v3Tov2 {
+		if t2 == typeURL {
+			return t3
+		}
+	}
+	return typeURL
+}
+
+// IsV3 returns true if typeURL is a v3 type.
+func IsV3(typeURL string) bool {
+	_, ok := v3Tov2[typeURL]
+	return ok
+}
@@ -0,0 +1,167 @@
+package server
+
+// this file implements the admin API, used by xdsctl to change and inspect the cache.
+
+import (
+	"context"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/miekg/xds/pkg/adminpb"
+	"github.com/miekg/xds/pkg/audit"
+	"github.com/miekg/xds/pkg/cache"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type admin struct {
+	s *server
+}
+
+// Admin returns the handlers for the admin API, these share the cache with the xDS ones.
+func (s *server) Admin() adminpb.AdminServiceServer { return &admin{s: s} }
+
+func (a *admin) SetEndpointHealth(ctx context.Context, req *adminpb.SetEndpointHealthRequest) (*adminpb.SetEndpointHealthResponse, error) {
+	if err := a.s.authorize(ctx, ActionHealth, []string{req.GetCluster()}); err != nil {
+		return nil, err
+	}
+	changes := audit.Changes{}
+	err := a.s.cache.SetEndpointHealth(req.GetCluster(), req.GetEndpoint(), corepb2.HealthStatus(req.GetHealth()), changes.Observe)
+	a.s.record(ctx, "", changes)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	a.s.cache.MarkDirty(req.GetCluster())
+	return &adminpb.SetEndpointHealthResponse{Version: a.s.cache.Version()}, nil
+}
+
+func (a *admin) SetEndpointWeight(ctx context.Context, req *adminpb.SetEndpointWeightRequest) (*adminpb.SetEndpointWeightResponse, error) {
+	if err := a.s.authorize(ctx, ActionWeight, []string{req.GetCluster()}); err != nil {
+		return nil, err
+	}
+	changes := audit.Changes{}
+	err := a.s.cache.SetEndpointWeight(req.GetCluster(), req.GetEndpoint(), req.GetWeight(), changes.Observe)
+	a.s.record(ctx, "", changes)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	a.s.cache.MarkDirty(req.GetCluster())
+	return &adminpb.SetEndpointWeightResponse{Version: a.s.cache.Version()}, nil
+}
+
+func (a *admin) SetLocalityWeight(ctx context.Context, req *adminpb.SetLocalityWeightRequest) (*adminpb.SetLocalityWeightResponse, error) {
+	if err := a.s.authorize(ctx, ActionWeight, []string{req.GetCluster()}); err != nil {
+		return nil, err
+	}
+	changes := audit.Changes{}
+	err := a.s.cache.SetLocalityWeight(req.GetCluster(), req.GetLocality(), req.GetWeight(), changes.Observe)
+	a.s.record(ctx, "", changes)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	a.s.cache.MarkDirty(req.GetCluster())
+	return &adminpb.SetLocalityWeightResponse{Version: a.s.cache.Version()}, nil
+}
+
+func (a *admin) AddEndpoint(ctx context.Context, req *adminpb.AddEndpointRequest) (*adminpb.AddEndpointResponse, error) {
+	if err := a.s.authorize(ctx, ActionEndpoint, []string{req.GetCluster()}); err != nil {
+		return nil, err
+	}
+	if _, err := cache.ParseEndpoint(req.GetEndpoint()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if cl, _ := a.s.cache.Retrieve(req.GetCluster()); cl == nil {
+		return nil, status.Errorf(codes.NotFound, "cluster %q not found", req.GetCluster())
+	}
+	changes := audit.Changes{}
+	err := a.s.cache.AddEndpoint(req.GetCluster(), req.GetEndpoint(), req.GetLocality(), req.GetWeight(), changes.Observe)
+	a.s.record(ctx, "", changes)
+	if err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	a.s.cache.MarkDirty(req.GetCluster())
+	return &adminpb.AddEndpointResponse{Version: a.s.cache.Version()}, nil
+}
+
+func (a *admin) RemoveEndpoint(ctx context.Context, req *adminpb.RemoveEndpointRequest) (*adminpb.RemoveEndpointResponse, error) {
+	if err := a.s.authorize(ctx, ActionEndpoint, []string{req.GetCluster()}); err != nil {
+		return nil, err
+	}
+	changes := audit.Changes{}
+	err := a.s.cache.RemoveEndpoint(req.GetCluster(), req.GetEndpoint(), changes.Observe)
+	a.s.record(ctx, "", changes)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	a.s.cache.MarkDirty(req.GetCluster())
+	return &adminpb.RemoveEndpointResponse{Version: a.s.cache.Version()}, nil
+}
+
+func (a *admin) UpsertCluster(ctx context.Context, req *adminpb.UpsertClusterRequest) (*adminpb.UpsertClusterResponse, error) {
+	cl := &xdspb2.Cluster{}
+	if err := ptypes.UnmarshalAny(req.GetCluster(), cl); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := a.s.authorize(ctx, ActionCluster, []string{cl.GetName()}); err != nil {
+		return nil, err
+	}
+	if err := cache.Validate(cl); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// Keep the hash of the file the cluster came from (if any), so the cluster isn't overwritten when the
+	// configuration is reread, only when the file itself changes.
+	if cl.Metadata != nil {
+		delete(cl.Metadata.FilterMetadata, cache.HashKind)
+	}
+	if old, _ := a.s.cache.Retrieve(cl.GetName()); old != nil {
+		cache.SetHashInMetadata(cl, cache.HashFromMetadata(old))
+	}
+	changes := audit.Changes{}
+	a.s.cache.Insert(cl, changes.Observe)
+	a.s.record(ctx, "", changes)
+	a.s.cache.MarkDirty(cl.GetName())
+	return &adminpb.UpsertClusterResponse{Version: a.s.cache.Version()}, nil
+}
+
+func (a *admin) DeleteCluster(ctx context.Context, req *adminpb.DeleteClusterRequest) (*adminpb.DeleteClusterResponse, error) {
+	if err := a.s.authorize(ctx, ActionCluster, []string{req.GetCluster()}); err != nil {
+		return nil, err
+	}
+	if cl, _ := a.s.cache.Retrieve(req.GetCluster()); cl == nil {
+		return nil, status.Errorf(codes.NotFound, "cluster %q not found", req.GetCluster())
+	}
+	changes := audit.Changes{}
+	a.s.cache.Delete(req.GetCluster(), changes.Observe)
+	a.s.record(ctx, "", changes)
+	a.s.cache.MarkDirty(req.GetCluster())
+	return &adminpb.DeleteClusterResponse{Version: a.s.cache.Version()}, nil
+}
+
+func (a *admin) ListClusters(ctx context.Context, req *adminpb.ListClustersRequest) (*adminpb.ListClustersResponse, error) {
+	resp := &adminpb.ListClustersResponse{Version: a.s.cache.Version()}
+	for _, name := range a.s.cache.All() {
+		cl, _ := a.s.cache.Retrieve(name)
+		if cl == nil {
+			continue
+		}
+		any, err := ptypes.MarshalAny(cl)
+		if err != nil {
+			return nil, err
+		}
+		resp.Clusters = append(resp.Clusters, any)
+	}
+	return resp, nil
+}
+
+func (a *admin) GetCluster(ctx context.Context, req *adminpb.GetClusterRequest) (*adminpb.GetClusterResponse, error) {
+	cl, version := a.s.cache.Retrieve(req.GetCluster())
+	if cl == nil {
+		return nil, status.Errorf(codes.NotFound, "cluster %q not found", req.GetCluster())
+	}
+	any, err := ptypes.MarshalAny(cl)
+	if err != nil {
+		return nil, err
+	}
+	return &adminpb.GetClusterResponse{Cluster: any, Version: version}, nil
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+
+	"github.com/miekg/xds/pkg/audit"
+	"google.golang.org/grpc/peer"
+)
+
+// caller returns who is calling in ctx, for the audit log.
+func (s *server) caller(ctx context.Context, node string) audit.Caller {
+	c := audit.Caller{Identity: certIdentity(ctx), Node: node}
+	if s.policy != nil {
+		c.Identity = s.policy.Identity(ctx)
+	}
+	if pr, ok := peer.FromContext(ctx); ok && pr.Addr != nil {
+		c.Peer = pr.Addr.String()
+	}
+	return c
+}
+
+// record records changes, made by the caller in ctx, in the audit log.
+func (s *server) record(ctx context.Context, node string, changes audit.Changes) {
+	if s.audit == nil || len(changes) == 0 {
+		return
+	}
+	s.audit.Record(s.caller(ctx, node), changes...)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	loadpb2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Actions that change the cache (or the server) and need to be authorized. Discovery requests and the read-only admin calls are
+// always allowed.
+const (
+	ActionHealth   = "health"   // set the health of endpoints, i.e. xdsctl drain, undrain and health.
+	ActionReport   = "report"   // report health as a health checker (HDS), this leaves DRAINING endpoints alone.
+	ActionLoad     = "load"     // report load (LRS), this sets the load in the cluster's metadata.
+	ActionWeight   = "weight"   // set the weight of endpoints and localities.
+	ActionEndpoint = "endpoint" // add and remove endpoints.
+	ActionCluster  = "cluster"  // create, replace and delete clusters.
+	ActionLogging  = "logging"  // change log levels via the HTTP admin interface, the cluster is empty, so use "*".
+)
+
+// Policy maps identities to the clusters they may modify. Callers are identified by their client certificate
+// (the first URI SAN, or the common name if there are none) or by a bearer token in the "authorization"
+// metadata.
+type Policy struct {
+	// Tokens maps bearer tokens to identities.
+	Tokens map[string]string `json:"tokens,omitempty"`
+	// Groups maps a group name to its members.
+	Groups map[string][]string `json:"groups,omitempty"`
+	// Rules hold what identities may do, if no rule allows an action it is denied.
+	Rules []Rule `json:"rules"`
+}
+
+// Rule allows identities to perform actions on clusters. Identities can be identities or group names, identities
+// and clusters may be globs.
+type Rule struct {
+	Identities []string `json:"identities"`
+	Clusters   []string `json:"clusters"`
+	Actions    []string `json:"actions"`
+}
+
+// LoadPolicy loads a JSON policy from file.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Allowed returns true if identity may perform action on cluster.
+func (p *Policy) Allowed(identity, action, cluster string) bool {
+	if identity == "" {
+		return false
+	}
+	names := []string{identity}
+	for group, members := range p.Groups {
+		for _, m := range members {
+			if m == identity {
+				names = append(names, group)
+				break
+			}
+		}
+	}
+
+	for _, r := range p.Rules {
+		if contains(r.Actions, action) && globAny(r.Clusters, cluster) {
+			for _, n := range names {
+				if globAny(r.Identities, n) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Identity returns the identity of the caller in ctx, or the empty string if the caller is unknown. A bearer
+// token takes precedence over the client certificate.
+func (p *Policy) Identity(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, a := range md.Get("authorization") {
+			if strings.HasPrefix(a, "Bearer ") {
+				if id, ok := p.Tokens[strings.TrimPrefix(a, "Bearer ")]; ok {
+					return id
+				}
+			}
+		}
+	}
+	return certIdentity(ctx)
+}
+
+// HTTPIdentity returns the identity of the caller of the HTTP request r, from a bearer token in the Authorization
+// header or the verified client certificate, or the empty string if the caller is unknown.
+func (p *Policy) HTTPIdentity(r *http.Request) string {
+	if a := r.Header.Get("Authorization"); strings.HasPrefix(a, "Bearer ") {
+		if id, ok := p.Tokens[strings.TrimPrefix(a, "Bearer ")]; ok {
+			return id
+		}
+	}
+	if r.TLS == nil {
+		return ""
+	}
+	return stateIdentity(*r.TLS)
+}
+
+// certIdentity returns the identity from the verified client certificate in ctx.
+func certIdentity(ctx context.Context) string {
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ""
+	}
+	return stateIdentity(info.State)
+}
+
+func stateIdentity(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return cert.Subject.CommonName
+}
+
+// authorize checks if the caller in ctx may perform action on all clusters. If there is no policy everything
+// is allowed.
+func (s *server) authorize(ctx context.Context, action string, clusters []string) error {
+	if s.policy == nil {
+		return nil
+	}
+	id := s.policy.Identity(ctx)
+	for _, cl := range clusters {
+		if !s.policy.Allowed(id, action, cl) {
+			log.With("identity", id, "cluster", cl).Warningf("Denied %q for %q on cluster %q", action, id, cl)
+			return status.Errorf(codes.PermissionDenied, "%q is not allowed to %s cluster %q", id, action, cl)
+		}
+	}
+	return nil
+}
+
+// authorizeHealth checks if the caller in ctx may set the health of the endpoints in req.
+func (s *server) authorizeHealth(ctx context.Context, action string, req *healthpb2.EndpointHealthResponse) error {
+	if s.policy == nil {
+		return nil
+	}
+	return s.authorize(ctx, action, s.cache.HealthClusters(req))
+}
+
+// authorizeLoad checks if the caller in ctx may set the load of the clusters in req. The initial request, that
+// carries no stats, is always allowed.
+func (s *server) authorizeLoad(ctx context.Context, req *loadpb2.LoadStatsRequest) error {
+	if s.policy == nil {
+		return nil
+	}
+	clusters := []string{}
+	for _, cs := range req.GetClusterStats() {
+		if len(cs.GetUpstreamLocalityStats()) > 0 {
+			clusters = append(clusters, cs.GetClusterName())
+		}
+	}
+	return s.authorize(ctx, ActionLoad, clusters)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func globAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Client is a stream connected to the server, as shown by the admin interface.
+type Client struct {
+	ID          int64                  `json:"id"`
+	Node        string                 `json:"node"`
+	Cluster     string                 `json:"cluster,omitempty"`
+	Delta       bool                   `json:"delta,omitempty"` // incremental (delta) xDS stream.
+	Connected   time.Time              `json:"connected"`
+	LastRequest time.Time              `json:"last_request"`
+	Types       map[string]*TypeStatus `json:"types"` // type URL -> status
+}
+
+// TypeStatus is the status of a single type URL on a stream.
+type TypeStatus struct {
+	Sent   string `json:"sent,omitempty"`   // version of the last response we sent.
+	Acked  string `json:"acked,omitempty"`  // last version the client ACKed.
+	Nacked string `json:"nacked,omitempty"` // last version the client NACKed.
+
+	Resources map[string]string `json:"resources,omitempty"` // resource name -> version of what we've sent.
+}
+
+// setResources records the versions of resources we've sent. If replace is true these are all the
+// resources the client has, otherwise they are added to the ones sent earlier.
+func (ts *TypeStatus) setResources(resources map[string]string, replace bool) {
+	if replace || ts.Resources == nil {
+		ts.Resources = map[string]string{}
+	}
+	for n, v := range resources {
+		ts.Resources[n] = v
+	}
+}
+
+// clients keeps track of the connected streams.
+type clients struct {
+	mu sync.Mutex
+	id int64
+	m  map[int64]*Client
+}
+
+// add adds a new stream and returns its ID.
+func (c *clients) add(delta bool) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[int64]*Client{}
+	}
+	c.id++
+	now := time.Now()
+	c.m[c.id] = &Client{ID: c.id, Delta: delta, Connected: now, LastRequest: now, Types: map[string]*TypeStatus{}}
+	return c.id
+}
+
+func (c *clients) remove(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, id)
+}
+
+// request records a request for typeURL from node on stream id.
+func (c *clients) request(id int64, node, cluster, typeURL string) {
+	c.update(id, typeURL, func(cl *Client, ts *TypeStatus) {
+		cl.Node, cl.Cluster = node, cluster
+		cl.LastRequest = time.Now()
+	})
+}
+
+// update calls f with the client of stream id and its status for typeURL.
+func (c *clients) update(id int64, typeURL string, f func(*Client, *TypeStatus)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cl, ok := c.m[id]
+	if !ok {
+		return
+	}
+	ts, ok := cl.Types[typeURL]
+	if !ok {
+		ts = &TypeStatus{}
+		cl.Types[typeURL] = ts
+	}
+	f(cl, ts)
+}
+
+// list returns a copy of all clients, ordered by ID.
+func (c *clients) list() []Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]Client, 0, len(c.m))
+	for _, cl := range c.m {
+		cp := *cl
+		cp.Types = make(map[string]*TypeStatus, len(cl.Types))
+		for t, ts := range cl.Types {
+			tsc := *ts
+			tsc.Resources = make(map[string]string, len(ts.Resources))
+			for n, v := range ts.Resources {
+				tsc.Resources[n] = v
+			}
+			cp.Types[t] = &tsc
+		}
+		list = append(list, cp)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Clients returns the streams currently connected to the server.
+func (s *server) Clients() []Client { return s.clients.list() }
@@ -0,0 +1,173 @@
+package server
+
+// this file implements the client status discovery service (CSDS).
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	dumppb2 "github.com/envoyproxy/go-control-plane/envoy/admin/v2alpha"
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	statuspb2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	matcherpb2 "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/miekg/xds/pkg/resource"
+)
+
+// FetchClientStatus returns, for each connected node that matches the node matchers in req, the status of the
+// listeners, clusters and routes sent to it. Endpoints (EDS) can't be expressed in CSDS and are left out.
+func (s *server) FetchClientStatus(ctx context.Context, req *statuspb2.ClientStatusRequest) (*statuspb2.ClientStatusResponse, error) {
+	nodes := map[string]*statuspb2.ClientConfig{}
+	for _, cl := range s.clients.list() {
+		if !matchNode(req.GetNodeMatchers(), cl.Node) {
+			continue
+		}
+		cc, ok := nodes[cl.Node]
+		if !ok {
+			cc = &statuspb2.ClientConfig{Node: &corepb2.Node{Id: cl.Node, Cluster: cl.Cluster}}
+			nodes[cl.Node] = cc
+		}
+		types := make([]string, 0, len(cl.Types))
+		for t := range cl.Types {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			if c := perXdsConfig(t, cl.Types[t]); c != nil {
+				cc.XdsConfig = append(cc.XdsConfig, c)
+			}
+		}
+	}
+
+	resp := &statuspb2.ClientStatusResponse{}
+	for _, cc := range nodes {
+		resp.Config = append(resp.Config, cc)
+	}
+	sort.Slice(resp.Config, func(i, j int) bool { return resp.Config[i].Node.Id < resp.Config[j].Node.Id })
+	return resp, nil
+}
+
+func (s *server) StreamClientStatus(stream statuspb2.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp, err := s.FetchClientStatus(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// configStatus returns the status of the last response sent for a type URL.
+func configStatus(ts *TypeStatus) statuspb2.ConfigStatus {
+	switch {
+	case ts.Sent == "":
+		return statuspb2.ConfigStatus_NOT_SENT
+	case ts.Nacked == ts.Sent:
+		return statuspb2.ConfigStatus_ERROR
+	case ts.Acked == ts.Sent:
+		return statuspb2.ConfigStatus_SYNCED
+	}
+	return statuspb2.ConfigStatus_STALE
+}
+
+// perXdsConfig returns the config for typeURL, the resources only carry their name. It returns nil for types
+// CSDS doesn't know about.
+func perXdsConfig(typeURL string, ts *TypeStatus) *statuspb2.PerXdsConfig {
+	names := make([]string, 0, len(ts.Resources))
+	for n := range ts.Resources {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	c := &statuspb2.PerXdsConfig{Status: configStatus(ts)}
+	switch resource.V2(typeURL) {
+	case resource.ListenerType:
+		dump := &dumppb2.ListenersConfigDump{VersionInfo: ts.Acked}
+		for _, n := range names {
+			dump.DynamicListeners = append(dump.DynamicListeners, &dumppb2.ListenersConfigDump_DynamicListener{
+				Name:        n,
+				ActiveState: &dumppb2.ListenersConfigDump_DynamicListenerState{VersionInfo: ts.Resources[n], Listener: marshalAny(&xdspb2.Listener{Name: n})},
+			})
+		}
+		c.PerXdsConfig = &statuspb2.PerXdsConfig_ListenerConfig{ListenerConfig: dump}
+	case resource.ClusterType:
+		dump := &dumppb2.ClustersConfigDump{VersionInfo: ts.Acked}
+		for _, n := range names {
+			dump.DynamicActiveClusters = append(dump.DynamicActiveClusters, &dumppb2.ClustersConfigDump_DynamicCluster{
+				VersionInfo: ts.Resources[n], Cluster: marshalAny(&xdspb2.Cluster{Name: n}),
+			})
+		}
+		c.PerXdsConfig = &statuspb2.PerXdsConfig_ClusterConfig{ClusterConfig: dump}
+	case resource.RouteConfigType:
+		dump := &dumppb2.RoutesConfigDump{}
+		for _, n := range names {
+			dump.DynamicRouteConfigs = append(dump.DynamicRouteConfigs, &dumppb2.RoutesConfigDump_DynamicRouteConfig{
+				VersionInfo: ts.Resources[n], RouteConfig: marshalAny(&xdspb2.RouteConfiguration{Name: n}),
+			})
+		}
+		c.PerXdsConfig = &statuspb2.PerXdsConfig_RouteConfig{RouteConfig: dump}
+	default:
+		return nil
+	}
+	return c
+}
+
+func marshalAny(pb proto.Message) *any.Any {
+	a, _ := ptypes.MarshalAny(pb)
+	return a
+}
+
+// matchNode returns true if the node ID matches any of matchers, or if there are no matchers. Only the node
+// ID matcher is supported.
+func matchNode(matchers []*matcherpb2.NodeMatcher, id string) bool {
+	if len(matchers) == 0 {
+		return true
+	}
+	for _, m := range matchers {
+		if matchString(m.GetNodeId(), id) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchString(m *matcherpb2.StringMatcher, s string) bool {
+	if m == nil {
+		return true
+	}
+	ls := lower(s, m.GetIgnoreCase())
+	switch p := m.GetMatchPattern().(type) {
+	case *matcherpb2.StringMatcher_Exact:
+		return ls == lower(p.Exact, m.GetIgnoreCase())
+	case *matcherpb2.StringMatcher_Prefix:
+		return strings.HasPrefix(ls, lower(p.Prefix, m.GetIgnoreCase()))
+	case *matcherpb2.StringMatcher_Suffix:
+		return strings.HasSuffix(ls, lower(p.Suffix, m.GetIgnoreCase()))
+	case *matcherpb2.StringMatcher_SafeRegex:
+		re, err := regexp.Compile(p.SafeRegex.GetRegex())
+		return err == nil && re.MatchString(s)
+	}
+	return false
+}
+
+func lower(s string, ignoreCase bool) string {
+	if ignoreCase {
+		return strings.ToLower(s)
+	}
+	return s
+}
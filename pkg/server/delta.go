@@ -0,0 +1,316 @@
+package server
+
+// this file implements the incremental (delta) variant of the v2 xds protocol
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	discoverypb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/miekg/xds/pkg/cache"
+	"github.com/miekg/xds/pkg/resource"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type deltaStream2 interface {
+	grpc.ServerStream
+
+	Send(*xdspb2.DeltaDiscoveryResponse) error
+	Recv() (*xdspb2.DeltaDiscoveryRequest, error)
+}
+
+// epoch is unique for this process, it prefixes the resource versions on delta streams. The versions in the cache
+// start again from zero when xds restarts, without the epoch a reconnecting client's initial resource versions
+// could match different resources that happen to have the same version now.
+var epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// deltaVersion returns the version of a resource on a delta stream.
+func deltaVersion(version uint64) string { return epoch + "." + strconv.FormatUint(version, 10) }
+
+// deltaState tracks, for a single type URL on a single delta stream, the resources the client is subscribed to and
+// which versions of those the client has.
+type deltaState struct {
+	wildcard bool                // client subscribed to all resources
+	names    map[string]struct{} // resource names the client is subscribed to, if not wildcard
+	versions map[string]string   // resource name -> version the client has
+	nonce    string              // nonce of the last response we sent
+
+	// last holds the versions the client had before our unacknowledged responses, so we can roll back on a NACK.
+	last map[string]string
+	// sent holds the versions we've sent in the unacknowledged responses.
+	sent map[string]string
+	// nacked holds resource name -> version the client rejected, we will not push these again.
+	nacked map[string]string
+	// node is the ID of the node that subscribed, for metrics.
+	node string
+}
+
+func newDeltaState() *deltaState {
+	return &deltaState{
+		names:    map[string]struct{}{},
+		versions: map[string]string{},
+		nacked:   map[string]string{},
+	}
+}
+
+// subscribed returns the names the client is subscribed to, or nil if it is a wildcard subscription.
+func (d *deltaState) subscribed() []string {
+	if d.wildcard {
+		return nil
+	}
+	names := make([]string, 0, len(d.names))
+	for n := range d.names {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// deltaProcess handles a bi-di delta stream (v2) request.
+func (s *server) deltaProcess(stream deltaStream2, reqCh <-chan *xdspb2.DeltaDiscoveryRequest, defaultTypeURL string) error {
+	var streamNonce int64
+
+	// every time the cache changes we send updates (if there are any to this client).
+	watch, cancel := s.cache.Watch()
+	defer cancel()
+
+	var (
+		node  = &corepb2.Node{}
+		state = map[string]*deltaState{} // API string -> state for CDS/EDS/...
+	)
+	id := s.clients.add(true)
+	defer func() {
+		for typeURL, st := range state {
+			streams.Dec(typeURL, st.node)
+		}
+		s.clients.remove(id)
+	}()
+
+	// push sends all resources that are new or changed, and the ones that have been removed, since the last
+	// response to the client.
+	push := func(typeURL string, st *deltaState) error {
+		var (
+			resources []cache.Resource
+			version   uint64
+		)
+		if names := st.subscribed(); st.wildcard || len(names) > 0 {
+			var err error
+			if resources, version, err = s.cache.Resources(node, typeURL, names); err != nil {
+				return err
+			}
+		}
+
+		resp := &xdspb2.DeltaDiscoveryResponse{SystemVersionInfo: strconv.FormatUint(version, 10), TypeUrl: typeURL}
+		last := map[string]string{}
+		sent := map[string]string{}
+		seen := map[string]struct{}{}
+		for _, r := range resources {
+			seen[r.Name] = struct{}{}
+			v := deltaVersion(r.Version)
+			if st.versions[r.Name] == v || st.nacked[r.Name] == v {
+				continue
+			}
+			resp.Resources = append(resp.Resources, &xdspb2.Resource{Name: r.Name, Version: v, Resource: r.Any})
+			last[r.Name] = st.versions[r.Name]
+			sent[r.Name] = v
+		}
+		for n := range st.versions {
+			if _, ok := seen[n]; ok {
+				continue
+			}
+			resp.RemovedResources = append(resp.RemovedResources, n)
+			last[n] = st.versions[n]
+		}
+		if len(resp.Resources) == 0 && len(resp.RemovedResources) == 0 {
+			return nil
+		}
+		sort.Strings(resp.RemovedResources)
+
+		streamNonce += 1
+		resp.Nonce = strconv.FormatInt(streamNonce, 10)
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+		for n, v := range sent {
+			st.versions[n] = v
+		}
+		for _, n := range resp.RemovedResources {
+			delete(st.versions, n)
+		}
+		pushes.Inc(typeURL)
+		s.clients.update(id, typeURL, func(_ *Client, ts *TypeStatus) {
+			ts.Sent = resp.SystemVersionInfo
+			ts.setResources(st.versions, true)
+		})
+		st.nonce = resp.Nonce
+		// Earlier responses may not be acknowledged yet, keep what the client had before the oldest of those.
+		if st.last == nil {
+			st.last, st.sent = map[string]string{}, map[string]string{}
+		}
+		for n, v := range last {
+			if _, ok := st.last[n]; !ok {
+				st.last[n] = v
+			}
+		}
+		for n, v := range sent {
+			st.sent[n] = v
+		}
+		log.With("node", node.Id, "type_url", typeURL, "version", resp.SystemVersionInfo, "nonce", resp.Nonce).Debugf("updated %s for node with ID %q: %d changed and %d removed resources", typeURL, node.Id, len(resp.Resources), len(resp.RemovedResources))
+		return nil
+	}
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case req, more := <-reqCh:
+			if !more { // input stream ended or errored out
+				return nil
+			}
+			if req == nil {
+				return status.Errorf(codes.Unavailable, "empty request")
+			}
+
+			// node field in discovery request is delta-compressed
+			if req.Node != nil {
+				node = req.Node
+			} else {
+				req.Node = node
+			}
+
+			// type URL is required for ADS but is implicit for xDS
+			if defaultTypeURL == resource.AnyType {
+				if req.TypeUrl == "" {
+					return status.Errorf(codes.InvalidArgument, "type URL is required for ADS")
+				}
+			} else if req.TypeUrl == "" {
+				req.TypeUrl = defaultTypeURL
+			}
+
+			s.clients.request(id, node.Id, node.Cluster, req.TypeUrl)
+			st, ok := state[req.TypeUrl]
+			if !ok {
+				st = newDeltaState()
+				state[req.TypeUrl] = st
+				st.node = node.Id
+				streams.Inc(req.TypeUrl, st.node)
+				// the first request without any names is a wildcard subscription.
+				st.wildcard = len(req.ResourceNamesSubscribe) == 0
+				// versions from before a restart never match ours, so these resources are sent again.
+				for n, v := range req.InitialResourceVersions {
+					st.versions[n] = v
+				}
+			}
+
+			// A reply to an older response is stale, the client will see (and reply to) our latest one. The changes to
+			// the subscription it carries must still be applied, as they are not sent again.
+			if req.ResponseNonce != "" && req.ResponseNonce != st.nonce {
+				log.With("node", node.Id, "type_url", req.TypeUrl, "nonce", req.ResponseNonce).Debugf("Not acknowledging stale %s response %s from node %q, expected %s", req.TypeUrl, req.ResponseNonce, node.Id, st.nonce)
+			} else if req.ResponseNonce != "" {
+				if req.ErrorDetail != nil {
+					// roll back to what the client had before our last response
+					for n, v := range st.last {
+						if v == "" {
+							delete(st.versions, n)
+							continue
+						}
+						st.versions[n] = v
+					}
+					for n, v := range st.sent {
+						st.nacked[n] = v
+					}
+					nacks.Inc(req.TypeUrl)
+					s.clients.update(id, req.TypeUrl, func(_ *Client, ts *TypeStatus) { ts.Nacked = ts.Sent })
+					log.With("node", node.Id, "type_url", req.TypeUrl, "nonce", st.nonce).Warningf("Node %q rejected %s response %s: %s", node.Id, req.TypeUrl, st.nonce, req.ErrorDetail.GetMessage())
+				} else {
+					log.With("node", node.Id, "type_url", req.TypeUrl, "nonce", st.nonce).Debugf("Node %q acknowledged %s response %s", node.Id, req.TypeUrl, st.nonce)
+					s.clients.update(id, req.TypeUrl, func(_ *Client, ts *TypeStatus) { ts.Acked = ts.Sent })
+				}
+				st.last, st.sent = nil, nil
+			}
+
+			for _, n := range req.ResourceNamesSubscribe {
+				if n == "*" {
+					st.wildcard = true
+					continue
+				}
+				st.names[n] = struct{}{}
+			}
+			for _, n := range req.ResourceNamesUnsubscribe {
+				if n == "*" {
+					st.wildcard = false
+					continue
+				}
+				delete(st.names, n)
+				delete(st.versions, n)
+				delete(st.nacked, n)
+				delete(st.last, n)
+				delete(st.sent, n)
+			}
+
+			if err := push(req.TypeUrl, st); err != nil {
+				return err
+			}
+		case <-watch:
+			for _, tpy := range pushOrder {
+				st, ok := state[tpy]
+				if !ok {
+					continue
+				}
+				if err := push(tpy, st); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// deltaHandler converts a blocking read call to channels and initiates stream processing.
+func (s *server) deltaHandler(stream deltaStream2, typeURL string) error {
+	// a channel for receiving incoming requests
+	reqCh := make(chan *xdspb2.DeltaDiscoveryRequest)
+	reqStop := int32(0)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if atomic.LoadInt32(&reqStop) != 0 {
+				return
+			}
+			if err != nil {
+				close(reqCh)
+				return
+			}
+			reqCh <- req
+		}
+	}()
+
+	err := s.deltaProcess(stream, reqCh, typeURL)
+	atomic.StoreInt32(&reqStop, 1)
+	return err
+}
+
+func (s *server) DeltaAggregatedResources(stream discoverypb2.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	return s.deltaHandler(stream, resource.AnyType)
+}
+
+func (s *server) DeltaEndpoints(stream xdspb2.EndpointDiscoveryService_DeltaEndpointsServer) error {
+	return s.deltaHandler(stream, resource.EndpointType)
+}
+
+func (s *server) DeltaClusters(stream xdspb2.ClusterDiscoveryService_DeltaClustersServer) error {
+	return s.deltaHandler(stream, resource.ClusterType)
+}
+
+func (s *server) DeltaListeners(stream xdspb2.ListenerDiscoveryService_DeltaListenersServer) error {
+	return s.deltaHandler(stream, resource.ListenerType)
+}
+
+func (s *server) DeltaRoutes(stream xdspb2.RouteDiscoveryService_DeltaRoutesServer) error {
+	return s.deltaHandler(stream, resource.RouteConfigType)
+}
@@ -20,12 +20,19 @@ import (
 	"fmt"
 	"sync/atomic"
 
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/xds/pkg/audit"
+	clog "github.com/miekg/xds/pkg/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// hdsLog logs health discovery.
+var hdsLog = clog.New(clog.HDS)
+
 type healthStream interface {
 	grpc.ServerStream
 
@@ -33,10 +40,31 @@ type healthStream interface {
 	Recv() (*healthpb2.HealthCheckRequestOrEndpointHealthResponse, error)
 }
 
-// healthProcess handles a bi-di stream request.
+// healthProcess handles a bi-di health discovery stream. Once the client sends a health check request we hand it
+// the health checks for all clusters and their endpoints. The client will then report the health of the endpoints
+// back to us. If the clusters change the client receives an updated set of health checks.
 func (s *server) healthProcess(stream healthStream, reqCh <-chan *healthpb2.HealthCheckRequestOrEndpointHealthResponse) error {
-	send := func(resp *healthpb2.HealthCheckSpecifier) error {
-		return stream.Send(resp)
+	// every time the cache changes we check if the health checks have changed.
+	watch, cancel := s.cache.Watch()
+	defer cancel()
+
+	var (
+		node *corepb2.Node
+		last *healthpb2.HealthCheckSpecifier // what we've sent last
+	)
+
+	// send sends the health checks to the client, if they differ from the ones we've sent last.
+	send := func() error {
+		hs := s.cache.HealthCheckSpecifier()
+		if last != nil && proto.Equal(hs, last) {
+			return nil
+		}
+		if err := stream.Send(hs); err != nil {
+			return err
+		}
+		last = hs
+		hdsLog.With("node", node.GetId()).Debugf("Sent health checks for %d clusters to node %q", len(hs.ClusterHealthChecks), node.GetId())
+		return nil
 	}
 
 	for {
@@ -50,16 +78,32 @@ func (s *server) healthProcess(stream healthStream, reqCh <-chan *healthpb2.Heal
 			if req == nil {
 				return status.Errorf(codes.Unavailable, "empty request")
 			}
-			hreq, ok := req.RequestType.(*healthpb2.HealthCheckRequestOrEndpointHealthResponse_EndpointHealthResponse)
-			if !ok {
-				return status.Errorf(codes.Unavailable, "can only handle health check responses")
+			switch x := req.RequestType.(type) {
+			case *healthpb2.HealthCheckRequestOrEndpointHealthResponse_HealthCheckRequest:
+				node = x.HealthCheckRequest.GetNode()
+				hdsLog.With("node", node.GetId()).Infof("Node %q connected for health checking with capabilities: %v", node.GetId(), x.HealthCheckRequest.GetCapability().GetHealthCheckProtocols())
+				last = nil
+				if err := send(); err != nil {
+					return err
+				}
+			case *healthpb2.HealthCheckRequestOrEndpointHealthResponse_EndpointHealthResponse:
+				if err := s.authorizeHealth(stream.Context(), ActionReport, x.EndpointHealthResponse); err != nil {
+					return err
+				}
+				changes := audit.Changes{}
+				_, err := s.cache.ReportHealth("", x.EndpointHealthResponse, changes.Observe)
+				s.record(stream.Context(), node.GetId(), changes)
+				if err != nil {
+					return err
+				}
+			default:
+				return status.Errorf(codes.InvalidArgument, "unknown health check request type")
 			}
-
-			resp, err := s.cache.SetHealth(hreq.EndpointHealthResponse)
-			if err != nil {
-				return err
+		case <-watch:
+			if node == nil { // no health check request seen yet
+				continue
 			}
-			if err := send(resp); err != nil {
+			if err := send(); err != nil {
 				return err
 			}
 		}
@@ -91,15 +135,21 @@ func (s *server) healthHandler(stream healthStream) error {
 }
 
 func (s *server) StreamHealthCheck(stream healthpb2.HealthDiscoveryService_StreamHealthCheckServer) error {
-	return nil
+	return s.healthHandler(stream)
 }
 
 func (s *server) FetchHealthCheck(ctx context.Context, req *healthpb2.HealthCheckRequestOrEndpointHealthResponse) (*healthpb2.HealthCheckSpecifier, error) {
 	switch x := req.RequestType.(type) {
 	case *healthpb2.HealthCheckRequestOrEndpointHealthResponse_EndpointHealthResponse:
-		return s.cache.SetHealth(x.EndpointHealthResponse)
+		if err := s.authorizeHealth(ctx, ActionReport, x.EndpointHealthResponse); err != nil {
+			return nil, err
+		}
+		changes := audit.Changes{}
+		resp, err := s.cache.ReportHealth("", x.EndpointHealthResponse, changes.Observe)
+		s.record(ctx, "", changes)
+		return resp, err
 	case *healthpb2.HealthCheckRequestOrEndpointHealthResponse_HealthCheckRequest:
-		return nil, fmt.Errorf("not implemented")
+		return s.cache.HealthCheckSpecifier(), nil
 	}
 	return nil, fmt.Errorf("not handled")
 }
@@ -35,6 +35,10 @@ func (s *server) loadProcess(stream loadStream, reqCh <-chan *loadpb2.LoadStatsR
 			if req == nil {
 				return status.Errorf(codes.Unavailable, "empty request")
 			}
+			loadReports.Inc()
+			if err := s.authorizeLoad(stream.Context(), req); err != nil {
+				return err
+			}
 			resp, err := s.cache.SetLoad(req)
 			if err != nil {
 				return err
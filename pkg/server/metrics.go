@@ -0,0 +1,10 @@
+package server
+
+import "github.com/miekg/xds/pkg/metrics"
+
+var (
+	streams     = metrics.NewGauge("server", "streams", "Connected streams per type URL and node.", "type_url", "node")
+	pushes      = metrics.NewCounter("server", "pushes_total", "Responses pushed per type URL.", "type_url")
+	nacks       = metrics.NewCounter("server", "nacks_total", "Responses rejected by clients per type URL.", "type_url")
+	loadReports = metrics.NewCounter("server", "load_reports_total", "Load reports received.")
+)
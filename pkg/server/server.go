@@ -4,24 +4,27 @@ package server
 
 import (
 	"context"
-	"errors"
 	"strconv"
 	"sync/atomic"
-	"time"
 
 	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	discoverypb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
 	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
 	loadpb2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
+	statuspb2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	"github.com/miekg/xds/pkg/adminpb"
+	"github.com/miekg/xds/pkg/audit"
 	"github.com/miekg/xds/pkg/cache"
-	"github.com/miekg/xds/pkg/log"
+	clog "github.com/miekg/xds/pkg/log"
 	"github.com/miekg/xds/pkg/resource"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+var log = clog.New(clog.Server)
+
 // Server is a collection of handlers for streaming discovery (v2) requests.
 type Server interface {
 	discoverypb2.AggregatedDiscoveryServiceServer
@@ -31,9 +34,19 @@ type Server interface {
 	xdspb2.RouteDiscoveryServiceServer
 	loadpb2.LoadReportingServiceServer
 	healthpb2.HealthDiscoveryServiceServer
+	statuspb2.ClientStatusDiscoveryServiceServer
 
 	// Fetch is the universal fetch method for discovery requests
 	Fetch(context.Context, *xdspb2.DiscoveryRequest) (*xdspb2.DiscoveryResponse, error)
+
+	// V3 returns the handlers for the v3 API.
+	V3() Server3
+
+	// Admin returns the handlers for the admin API.
+	Admin() adminpb.AdminServiceServer
+
+	// Clients returns the streams currently connected to the server.
+	Clients() []Client
 }
 
 type discoveryStream2 interface {
@@ -43,18 +56,19 @@ type discoveryStream2 interface {
 	Recv() (*xdspb2.DiscoveryRequest, error)
 }
 
-// NewServer creates handlers from a config watcher and callbacks.
-func NewServer(ctx context.Context, config *cache.Cluster) Server {
-	return &server{cache: config, ctx: ctx}
+// NewServer creates handlers from a config watcher and callbacks. Calls that change the cache are checked
+// against policy, if policy is nil everything is allowed. The changes are recorded in audit, if not nil.
+func NewServer(ctx context.Context, config cache.Cache, policy *Policy, audit *audit.Log) Server {
+	return &server{cache: config, ctx: ctx, policy: policy, audit: audit}
 }
 
 type server struct {
-	cache *cache.Cluster
+	cache   cache.Cache
+	policy  *Policy
+	audit   *audit.Log // if not nil, changes to the cache are recorded here.
+	clients clients
 
 	ctx context.Context
-
-	// streamCount for counting bi-di streams
-	streamCount int64
 }
 
 // discoveryProcess handles a bi-di stream (v2) request.
@@ -63,19 +77,61 @@ func (s *server) discoveryProcess(stream discoveryStream2, reqCh <-chan *xdspb2.
 	// but for now we'll just copy and paste code around.
 	var streamNonce int64
 
-	send := func(resp *xdspb2.DiscoveryResponse) error {
-		streamNonce += 1
-		resp.Nonce = strconv.FormatInt(streamNonce, 10)
-		return stream.Send(resp)
-	}
-
-	tick := time.NewTicker(10 * time.Second) // every 10s we send updates (if there are any to this client).
-	defer tick.Stop()
+	// every time the cache changes we send updates (if there are any to this client).
+	watch, cancel := s.cache.Watch()
+	defer cancel()
 
 	var (
-		node        = &corepb2.Node{}
-		versionInfo = map[string]string{} // API string -> version CDS/EDS
+		node  = &corepb2.Node{}
+		state = map[string]*typeState{} // API string -> state for CDS/EDS/...
 	)
+	id := s.clients.add(false)
+	defer func() {
+		for typeURL, st := range state {
+			streams.Dec(typeURL, st.node)
+		}
+		s.clients.remove(id)
+	}()
+
+	// push sends the resources of typeURL the client is subscribed to, if there is something new to send. If force
+	// is true we send even if the version didn't change. For EDS and RDS only the resources that changed since
+	// our last response are sent.
+	push := func(typeURL string, st *typeState, force bool) (bool, error) {
+		resources, version, err := s.cache.Resources(node, typeURL, st.names)
+		if err != nil {
+			return false, err
+		}
+		versionInfo := strconv.FormatUint(version, 10)
+		if !force && (versionInfo == st.version || versionInfo == st.nacked) {
+			return false, nil
+		}
+		if partial(typeURL) {
+			resources = st.changed(resources)
+			if len(resources) == 0 {
+				return false, nil
+			}
+		}
+
+		resp := cache.DiscoveryResponse(typeURL, version, resources)
+		streamNonce += 1
+		resp.Nonce = strconv.FormatInt(streamNonce, 10)
+		if err := stream.Send(resp); err != nil {
+			return false, err
+		}
+		pushes.Inc(typeURL)
+		sent := make(map[string]string, len(resources))
+		for _, r := range resources {
+			sent[r.Name] = strconv.FormatUint(r.Version, 10)
+		}
+		s.clients.update(id, typeURL, func(_ *Client, ts *TypeStatus) {
+			ts.Sent = resp.GetVersionInfo()
+			ts.setResources(sent, !partial(typeURL))
+		})
+		st.nonce = resp.Nonce
+		st.version = resp.GetVersionInfo()
+		st.setSent(resources)
+		return true, nil
+	}
 
 	for {
 		select {
@@ -105,37 +161,57 @@ func (s *server) discoveryProcess(stream discoveryStream2, reqCh <-chan *xdspb2.
 				req.TypeUrl = defaultTypeURL
 			}
 
-			resp, err := s.cache.Fetch(req)
-			if err != nil {
-				return err
+			s.clients.request(id, node.Id, node.Cluster, req.TypeUrl)
+			st, ok := state[req.TypeUrl]
+			if !ok {
+				st = &typeState{node: node.Id}
+				state[req.TypeUrl] = st
+				streams.Inc(req.TypeUrl, st.node)
 			}
-			if resp.VersionInfo == versionInfo[req.TypeUrl] {
-				continue
+
+			if req.ResponseNonce != "" {
+				// A reply to an older response is stale, the client will see (and reply to) our latest one.
+				if req.ResponseNonce != st.nonce {
+					log.With("node", node.Id, "type_url", req.TypeUrl, "nonce", req.ResponseNonce).Debugf("Ignoring stale %s request from node %q with nonce %s, expected %s", req.TypeUrl, node.Id, req.ResponseNonce, st.nonce)
+					continue
+				}
+				if req.ErrorDetail != nil {
+					st.nacked = st.version
+					nacks.Inc(req.TypeUrl)
+					st.acked = req.VersionInfo
+					s.clients.update(id, req.TypeUrl, func(_ *Client, ts *TypeStatus) { ts.Nacked, ts.Acked = st.nacked, st.acked })
+					log.With("node", node.Id, "type_url", req.TypeUrl, "version", st.nacked, "nonce", req.ResponseNonce).Warningf("Node %q rejected %s version %s (keeping version %q): %s", node.Id, req.TypeUrl, st.nacked, st.acked, req.ErrorDetail.GetMessage())
+				} else {
+					st.acked = req.VersionInfo
+					s.clients.update(id, req.TypeUrl, func(_ *Client, ts *TypeStatus) { ts.Acked = st.acked })
+					log.With("node", node.Id, "type_url", req.TypeUrl, "version", st.acked, "nonce", req.ResponseNonce).Debugf("Node %q acknowledged %s version %s", node.Id, req.TypeUrl, st.acked)
+				}
+				// both an ACK and a NACK can carry a change to the subscription
+				if !st.namesChanged(req) {
+					continue
+				}
 			}
 
-			if err := send(resp); err != nil {
+			changed := st.namesChanged(req)
+			st.setNames(req)
+
+			if _, err := push(req.TypeUrl, st, changed); err != nil {
 				return err
 			}
-			versionInfo[req.TypeUrl] = resp.GetVersionInfo()
-		case <-tick.C:
-			req := &xdspb2.DiscoveryRequest{}
-
-			for _, tpy := range []string{resource.ClusterType, resource.EndpointType, resource.ListenerType, resource.RouteConfigType} {
-				req.VersionInfo = versionInfo[tpy]
-				req.TypeUrl = tpy
-				resp, err := s.cache.Fetch(req)
+		case <-watch:
+			for _, tpy := range pushOrder {
+				st, ok := state[tpy]
+				if !ok {
+					continue
+				}
+				sent, err := push(tpy, st, false)
 				if err != nil {
 					return err
 				}
-				if resp.VersionInfo == versionInfo[req.TypeUrl] {
+				if !sent {
 					continue
 				}
-
-				if err := send(resp); err != nil {
-					return err
-				}
-				versionInfo[req.TypeUrl] = resp.GetVersionInfo()
-				log.Infof("updated %s for node with ID %q with version: %s", req.TypeUrl, node.Id, versionInfo[req.TypeUrl])
+				log.With("node", node.Id, "type_url", tpy, "version", st.version, "nonce", st.nonce).Infof("updated %s for node with ID %q with version: %s", tpy, node.Id, st.version)
 			}
 		}
 	}
@@ -210,23 +286,3 @@ func (s *server) FetchRoutes(ctx context.Context, req *xdspb2.DiscoveryRequest)
 	req.TypeUrl = resource.RouteConfigType
 	return s.Fetch(ctx, req)
 }
-
-func (s *server) DeltaAggregatedResources(_ discoverypb2.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
-	return errors.New("not implemented")
-}
-
-func (s *server) DeltaEndpoints(_ xdspb2.EndpointDiscoveryService_DeltaEndpointsServer) error {
-	return errors.New("not implemented")
-}
-
-func (s *server) DeltaClusters(_ xdspb2.ClusterDiscoveryService_DeltaClustersServer) error {
-	return errors.New("not implemented")
-}
-
-func (s *server) DeltaListeners(_ xdspb2.ListenerDiscoveryService_DeltaListenersServer) error {
-	return errors.New("not implemented")
-}
-
-func (s *server) DeltaRoutes(_ xdspb2.RouteDiscoveryService_DeltaRoutesServer) error {
-	return errors.New("not implemented")
-}
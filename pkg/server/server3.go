@@ -0,0 +1,314 @@
+package server
+
+// this file implements the v3 version of the xds protocol. Requests are translated to v2, handled by the v2 server
+// and the responses are translated back to v3. The cache hands out v3 resources when asked for v3 type URLs.
+
+import (
+	"context"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	clustersvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	discoverypb3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointsvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	healthpb3 "github.com/envoyproxy/go-control-plane/envoy/service/health/v3"
+	listenersvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	loadpb2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
+	loadpb3 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v3"
+	routesvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	statuspb2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	statuspb3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/xds/pkg/resource"
+	"google.golang.org/grpc"
+)
+
+// Server3 is a collection of handlers for streaming discovery (v3) requests.
+type Server3 interface {
+	discoverypb3.AggregatedDiscoveryServiceServer
+	endpointsvcpb3.EndpointDiscoveryServiceServer
+	clustersvcpb3.ClusterDiscoveryServiceServer
+	listenersvcpb3.ListenerDiscoveryServiceServer
+	routesvcpb3.RouteDiscoveryServiceServer
+	loadpb3.LoadReportingServiceServer
+	healthpb3.HealthDiscoveryServiceServer
+	statuspb3.ClientStatusDiscoveryServiceServer
+}
+
+type server3 struct {
+	s *server
+}
+
+// V3 returns the v3 handlers, these share the cache with the v2 ones.
+func (s *server) V3() Server3 { return &server3{s: s} }
+
+// convert converts src into dst by marshaling and unmarshaling it. This works between v2 and v3 messages, because
+// these are wire compatible.
+func convert(src, dst proto.Message) error {
+	data, err := proto.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, dst)
+}
+
+type discoveryStream3 interface {
+	grpc.ServerStream
+
+	Send(*discoverypb3.DiscoveryResponse) error
+	Recv() (*discoverypb3.DiscoveryRequest, error)
+}
+
+// discoveryStream3to2 makes a v3 discovery stream look like a v2 one.
+type discoveryStream3to2 struct {
+	discoveryStream3
+}
+
+func (d discoveryStream3to2) Send(resp *xdspb2.DiscoveryResponse) error {
+	resp3 := &discoverypb3.DiscoveryResponse{}
+	if err := convert(resp, resp3); err != nil {
+		return err
+	}
+	return d.discoveryStream3.Send(resp3)
+}
+
+func (d discoveryStream3to2) Recv() (*xdspb2.DiscoveryRequest, error) {
+	req3, err := d.discoveryStream3.Recv()
+	if err != nil {
+		return nil, err
+	}
+	req := &xdspb2.DiscoveryRequest{}
+	return req, convert(req3, req)
+}
+
+type deltaStream3 interface {
+	grpc.ServerStream
+
+	Send(*discoverypb3.DeltaDiscoveryResponse) error
+	Recv() (*discoverypb3.DeltaDiscoveryRequest, error)
+}
+
+// deltaStream3to2 makes a v3 delta discovery stream look like a v2 one.
+type deltaStream3to2 struct {
+	deltaStream3
+}
+
+func (d deltaStream3to2) Send(resp *xdspb2.DeltaDiscoveryResponse) error {
+	resp3 := &discoverypb3.DeltaDiscoveryResponse{}
+	if err := convert(resp, resp3); err != nil {
+		return err
+	}
+	return d.deltaStream3.Send(resp3)
+}
+
+func (d deltaStream3to2) Recv() (*xdspb2.DeltaDiscoveryRequest, error) {
+	req3, err := d.deltaStream3.Recv()
+	if err != nil {
+		return nil, err
+	}
+	req := &xdspb2.DeltaDiscoveryRequest{}
+	return req, convert(req3, req)
+}
+
+// loadStream3to2 makes a v3 load reporting stream look like a v2 one.
+type loadStream3to2 struct {
+	loadpb3.LoadReportingService_StreamLoadStatsServer
+}
+
+func (l loadStream3to2) Send(resp *loadpb2.LoadStatsResponse) error {
+	resp3 := &loadpb3.LoadStatsResponse{}
+	if err := convert(resp, resp3); err != nil {
+		return err
+	}
+	return l.LoadReportingService_StreamLoadStatsServer.Send(resp3)
+}
+
+func (l loadStream3to2) Recv() (*loadpb2.LoadStatsRequest, error) {
+	req3, err := l.LoadReportingService_StreamLoadStatsServer.Recv()
+	if err != nil {
+		return nil, err
+	}
+	req := &loadpb2.LoadStatsRequest{}
+	return req, convert(req3, req)
+}
+
+// healthStream3to2 makes a v3 health discovery stream look like a v2 one.
+type healthStream3to2 struct {
+	healthpb3.HealthDiscoveryService_StreamHealthCheckServer
+}
+
+func (h healthStream3to2) Send(resp *healthpb2.HealthCheckSpecifier) error {
+	resp3 := &healthpb3.HealthCheckSpecifier{}
+	if err := convert(resp, resp3); err != nil {
+		return err
+	}
+	return h.HealthDiscoveryService_StreamHealthCheckServer.Send(resp3)
+}
+
+func (h healthStream3to2) Recv() (*healthpb2.HealthCheckRequestOrEndpointHealthResponse, error) {
+	req3, err := h.HealthDiscoveryService_StreamHealthCheckServer.Recv()
+	if err != nil {
+		return nil, err
+	}
+	req := &healthpb2.HealthCheckRequestOrEndpointHealthResponse{}
+	return req, convert(req3, req)
+}
+
+func (s *server3) StreamAggregatedResources(stream discoverypb3.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	return s.s.discoveryHandler(discoveryStream3to2{stream}, resource.AnyType)
+}
+
+func (s *server3) StreamEndpoints(stream endpointsvcpb3.EndpointDiscoveryService_StreamEndpointsServer) error {
+	return s.s.discoveryHandler(discoveryStream3to2{stream}, resource.EndpointType3)
+}
+
+func (s *server3) StreamClusters(stream clustersvcpb3.ClusterDiscoveryService_StreamClustersServer) error {
+	return s.s.discoveryHandler(discoveryStream3to2{stream}, resource.ClusterType3)
+}
+
+func (s *server3) StreamListeners(stream listenersvcpb3.ListenerDiscoveryService_StreamListenersServer) error {
+	return s.s.discoveryHandler(discoveryStream3to2{stream}, resource.ListenerType3)
+}
+
+func (s *server3) StreamRoutes(stream routesvcpb3.RouteDiscoveryService_StreamRoutesServer) error {
+	return s.s.discoveryHandler(discoveryStream3to2{stream}, resource.RouteConfigType3)
+}
+
+func (s *server3) DeltaAggregatedResources(stream discoverypb3.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	return s.s.deltaHandler(deltaStream3to2{stream}, resource.AnyType)
+}
+
+func (s *server3) DeltaEndpoints(stream endpointsvcpb3.EndpointDiscoveryService_DeltaEndpointsServer) error {
+	return s.s.deltaHandler(deltaStream3to2{stream}, resource.EndpointType3)
+}
+
+func (s *server3) DeltaClusters(stream clustersvcpb3.ClusterDiscoveryService_DeltaClustersServer) error {
+	return s.s.deltaHandler(deltaStream3to2{stream}, resource.ClusterType3)
+}
+
+func (s *server3) DeltaListeners(stream listenersvcpb3.ListenerDiscoveryService_DeltaListenersServer) error {
+	return s.s.deltaHandler(deltaStream3to2{stream}, resource.ListenerType3)
+}
+
+func (s *server3) DeltaRoutes(stream routesvcpb3.RouteDiscoveryService_DeltaRoutesServer) error {
+	return s.s.deltaHandler(deltaStream3to2{stream}, resource.RouteConfigType3)
+}
+
+// fetch translates req to v2, fetches the typeURL resources and translates the response back to v3.
+func (s *server3) fetch(ctx context.Context, req *discoverypb3.DiscoveryRequest, typeURL string) (*discoverypb3.DiscoveryResponse, error) {
+	req2 := &xdspb2.DiscoveryRequest{}
+	if err := convert(req, req2); err != nil {
+		return nil, err
+	}
+	req2.TypeUrl = typeURL
+	resp2, err := s.s.Fetch(ctx, req2)
+	if err != nil {
+		return nil, err
+	}
+	resp := &discoverypb3.DiscoveryResponse{}
+	return resp, convert(resp2, resp)
+}
+
+func (s *server3) FetchClusters(ctx context.Context, req *discoverypb3.DiscoveryRequest) (*discoverypb3.DiscoveryResponse, error) {
+	return s.fetch(ctx, req, resource.ClusterType3)
+}
+
+func (s *server3) FetchEndpoints(ctx context.Context, req *discoverypb3.DiscoveryRequest) (*discoverypb3.DiscoveryResponse, error) {
+	return s.fetch(ctx, req, resource.EndpointType3)
+}
+
+func (s *server3) FetchListeners(ctx context.Context, req *discoverypb3.DiscoveryRequest) (*discoverypb3.DiscoveryResponse, error) {
+	return s.fetch(ctx, req, resource.ListenerType3)
+}
+
+func (s *server3) FetchRoutes(ctx context.Context, req *discoverypb3.DiscoveryRequest) (*discoverypb3.DiscoveryResponse, error) {
+	return s.fetch(ctx, req, resource.RouteConfigType3)
+}
+
+func (s *server3) StreamLoadStats(stream loadpb3.LoadReportingService_StreamLoadStatsServer) error {
+	return s.s.StreamLoadStats(loadStream3to2{stream})
+}
+
+func (s *server3) StreamHealthCheck(stream healthpb3.HealthDiscoveryService_StreamHealthCheckServer) error {
+	return s.s.StreamHealthCheck(healthStream3to2{stream})
+}
+
+func (s *server3) FetchHealthCheck(ctx context.Context, req *healthpb3.HealthCheckRequestOrEndpointHealthResponse) (*healthpb3.HealthCheckSpecifier, error) {
+	req2 := &healthpb2.HealthCheckRequestOrEndpointHealthResponse{}
+	if err := convert(req, req2); err != nil {
+		return nil, err
+	}
+	resp2, err := s.s.FetchHealthCheck(ctx, req2)
+	if err != nil {
+		return nil, err
+	}
+	resp := &healthpb3.HealthCheckSpecifier{}
+	return resp, convert(resp2, resp)
+}
+
+// clientStatusStream3to2 makes a v3 client status stream look like a v2 one.
+type clientStatusStream3to2 struct {
+	statuspb3.ClientStatusDiscoveryService_StreamClientStatusServer
+}
+
+func (c clientStatusStream3to2) Send(resp *statuspb2.ClientStatusResponse) error {
+	resp3, err := clientStatus3(resp)
+	if err != nil {
+		return err
+	}
+	return c.ClientStatusDiscoveryService_StreamClientStatusServer.Send(resp3)
+}
+
+func (c clientStatusStream3to2) Recv() (*statuspb2.ClientStatusRequest, error) {
+	req3, err := c.ClientStatusDiscoveryService_StreamClientStatusServer.Recv()
+	if err != nil {
+		return nil, err
+	}
+	req := &statuspb2.ClientStatusRequest{}
+	return req, convert(req3, req)
+}
+
+// clientStatus3 converts a CSDS response to v3. The resources in it are packed as v2 ones, these only carry a
+// name, which is the same in v3, so only their types need to change.
+func clientStatus3(resp *statuspb2.ClientStatusResponse) (*statuspb3.ClientStatusResponse, error) {
+	resp3 := &statuspb3.ClientStatusResponse{}
+	if err := convert(resp, resp3); err != nil {
+		return nil, err
+	}
+	for _, cc := range resp3.GetConfig() {
+		for _, x := range cc.GetXdsConfig() {
+			for _, l := range x.GetListenerConfig().GetDynamicListeners() {
+				if a := l.GetActiveState().GetListener(); a != nil {
+					a.TypeUrl = resource.V3(a.TypeUrl)
+				}
+			}
+			for _, c := range x.GetClusterConfig().GetDynamicActiveClusters() {
+				if a := c.GetCluster(); a != nil {
+					a.TypeUrl = resource.V3(a.TypeUrl)
+				}
+			}
+			for _, r := range x.GetRouteConfig().GetDynamicRouteConfigs() {
+				if a := r.GetRouteConfig(); a != nil {
+					a.TypeUrl = resource.V3(a.TypeUrl)
+				}
+			}
+		}
+	}
+	return resp3, nil
+}
+
+func (s *server3) FetchClientStatus(ctx context.Context, req *statuspb3.ClientStatusRequest) (*statuspb3.ClientStatusResponse, error) {
+	req2 := &statuspb2.ClientStatusRequest{}
+	if err := convert(req, req2); err != nil {
+		return nil, err
+	}
+	resp2, err := s.s.FetchClientStatus(ctx, req2)
+	if err != nil {
+		return nil, err
+	}
+	return clientStatus3(resp2)
+}
+
+func (s *server3) StreamClientStatus(stream statuspb3.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	return s.s.StreamClientStatus(clientStatusStream3to2{stream})
+}
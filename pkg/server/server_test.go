@@ -0,0 +1,558 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	edspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	loadpb2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
+	statuspb2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	statuspb3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/miekg/xds/pkg/adminpb"
+	"github.com/miekg/xds/pkg/audit"
+	"github.com/miekg/xds/pkg/cache"
+	"github.com/miekg/xds/pkg/resource"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+type mockStream struct {
+	grpc.ServerStream
+	sent chan *xdspb2.DiscoveryResponse
+}
+
+func (m *mockStream) Send(resp *xdspb2.DiscoveryResponse) error { m.sent <- resp; return nil }
+func (m *mockStream) Recv() (*xdspb2.DiscoveryRequest, error)   { return nil, io.EOF }
+
+func newCluster(name string) *xdspb2.Cluster {
+	return &xdspb2.Cluster{Name: name, LoadAssignment: &xdspb2.ClusterLoadAssignment{ClusterName: name}}
+}
+
+// newEndpointCluster returns a cluster with a single endpoint, 127.0.0.1:80, which is returned as well.
+func newEndpointCluster(name string) (*xdspb2.Cluster, *edspb2.Endpoint) {
+	ep := &edspb2.Endpoint{Address: &corepb2.Address{Address: &corepb2.Address_SocketAddress{
+		SocketAddress: &corepb2.SocketAddress{Address: "127.0.0.1", PortSpecifier: &corepb2.SocketAddress_PortValue{PortValue: 80}},
+	}}}
+	cl := newCluster(name)
+	cl.LoadAssignment.Endpoints = []*edspb2.LocalityLbEndpoints{{
+		LbEndpoints: []*edspb2.LbEndpoint{{HostIdentifier: &edspb2.LbEndpoint_Endpoint{Endpoint: ep}}},
+	}}
+	return cl, ep
+}
+
+func expectResponse(t *testing.T, m *mockStream) *xdspb2.DiscoveryResponse {
+	t.Helper()
+	select {
+	case resp := <-m.sent:
+		return resp
+	case <-time.After(time.Second):
+		t.Fatal("Expected a response, got none")
+	}
+	return nil
+}
+
+func expectNoResponse(t *testing.T, m *mockStream) {
+	t.Helper()
+	select {
+	case resp := <-m.sent:
+		t.Fatalf("Expected no response, got version %s", resp.VersionInfo)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDiscoveryAckNack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := cache.New()
+	c.Insert(newCluster("a"))
+	s := &server{cache: c, ctx: ctx}
+
+	m := &mockStream{sent: make(chan *xdspb2.DiscoveryResponse, 1)}
+	reqCh := make(chan *xdspb2.DiscoveryRequest)
+	go s.discoveryProcess(m, reqCh, resource.ClusterType)
+
+	reqCh <- &xdspb2.DiscoveryRequest{}
+	resp := expectResponse(t, m)
+	if resp.VersionInfo != "1" {
+		t.Fatalf("Expected version %s, got %s", "1", resp.VersionInfo)
+	}
+
+	// ACK
+	reqCh <- &xdspb2.DiscoveryRequest{VersionInfo: "1", ResponseNonce: resp.Nonce}
+	expectNoResponse(t, m)
+
+	// changes are pushed
+	c.Insert(newCluster("b"))
+	resp = expectResponse(t, m)
+	if resp.VersionInfo != "2" {
+		t.Fatalf("Expected version %s, got %s", "2", resp.VersionInfo)
+	}
+
+	// NACK, version 2 should not be send again.
+	reqCh <- &xdspb2.DiscoveryRequest{VersionInfo: "1", ResponseNonce: resp.Nonce, ErrorDetail: &status.Status{Message: "bad cluster"}}
+	expectNoResponse(t, m)
+	reqCh <- &xdspb2.DiscoveryRequest{VersionInfo: "1"}
+	expectNoResponse(t, m)
+
+	// stale nonce
+	reqCh <- &xdspb2.DiscoveryRequest{VersionInfo: "1", ResponseNonce: "1", ResourceNames: []string{"a"}}
+	expectNoResponse(t, m)
+
+	// new version is pushed again
+	c.Insert(newCluster("c"))
+	resp = expectResponse(t, m)
+	if resp.VersionInfo != "3" {
+		t.Fatalf("Expected version %s, got %s", "3", resp.VersionInfo)
+	}
+}
+
+func TestDiscoveryNackNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := cache.New()
+	c.Insert(newCluster("a"))
+	c.Insert(newCluster("b"))
+	s := &server{cache: c, ctx: ctx}
+
+	m := &mockStream{sent: make(chan *xdspb2.DiscoveryResponse, 1)}
+	reqCh := make(chan *xdspb2.DiscoveryRequest)
+	go s.discoveryProcess(m, reqCh, resource.EndpointType)
+
+	reqCh <- &xdspb2.DiscoveryRequest{ResourceNames: []string{"a"}}
+	resp := expectResponse(t, m)
+
+	// the NACK subscribes to "b" as well, which must be sent.
+	reqCh <- &xdspb2.DiscoveryRequest{ResponseNonce: resp.Nonce, ResourceNames: []string{"a", "b"}, ErrorDetail: &status.Status{Message: "bad endpoints"}}
+	resp = expectResponse(t, m)
+	if len(resp.Resources) != 1 {
+		t.Fatalf("Expected %d resource, got %d", 1, len(resp.Resources))
+	}
+	cla := &xdspb2.ClusterLoadAssignment{}
+	if err := ptypes.UnmarshalAny(resp.Resources[0], cla); err != nil {
+		t.Fatal(err)
+	}
+	if cla.ClusterName != "b" {
+		t.Errorf("Expected cluster %q, got %q", "b", cla.ClusterName)
+	}
+}
+
+// nackStream runs a CDS stream on s that ACKs the cluster "a" and NACKs the update adding "b" to c.
+func nackStream(t *testing.T, s *server, c *cache.Cluster) {
+	t.Helper()
+	c.Insert(newCluster("a"))
+	m := &mockStream{sent: make(chan *xdspb2.DiscoveryResponse, 1)}
+	reqCh := make(chan *xdspb2.DiscoveryRequest)
+	go s.discoveryProcess(m, reqCh, resource.ClusterType)
+
+	reqCh <- &xdspb2.DiscoveryRequest{}
+	resp := expectResponse(t, m)
+	reqCh <- &xdspb2.DiscoveryRequest{VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce}
+	c.Insert(newCluster("b"))
+	resp = expectResponse(t, m)
+	reqCh <- &xdspb2.DiscoveryRequest{VersionInfo: "1", ResponseNonce: resp.Nonce, ErrorDetail: &status.Status{Message: "bad cluster"}}
+	expectNoResponse(t, m)
+}
+
+func TestClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := cache.New()
+	s := &server{cache: c, ctx: ctx}
+	nackStream(t, s, c)
+
+	clients := s.Clients()
+	if len(clients) != 1 {
+		t.Fatalf("Expected %d client, got %d", 1, len(clients))
+	}
+	if ts := clients[0].Types[resource.ClusterType]; ts.Acked != "1" || ts.Nacked != "2" || ts.Sent != "2" {
+		t.Errorf("Expected sent/acked/nacked %s/%s/%s, got %s/%s/%s", "2", "1", "2", ts.Sent, ts.Acked, ts.Nacked)
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := cache.New()
+	s := &server{cache: c, ctx: ctx}
+	nackStream(t, s, c)
+
+	csds, err := s.FetchClientStatus(ctx, &statuspb2.ClientStatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := csds.GetConfig()[0].GetXdsConfig()[0]
+	if x.GetStatus() != statuspb2.ConfigStatus_ERROR {
+		t.Errorf("Expected status %s, got %s", statuspb2.ConfigStatus_ERROR, x.GetStatus())
+	}
+	if n := len(x.GetClusterConfig().GetDynamicActiveClusters()); n != 2 {
+		t.Errorf("Expected %d clusters sent, got %d", 2, n)
+	}
+
+	// v3 carries the same status, with v3 resources.
+	csds3, err := s.V3().FetchClientStatus(ctx, &statuspb3.ClientStatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x3 := csds3.GetConfig()[0].GetXdsConfig()[0]
+	if cl := x3.GetClusterConfig().GetDynamicActiveClusters()[0].GetCluster(); cl.GetTypeUrl() != resource.ClusterType3 {
+		t.Errorf("Expected v3 cluster type %s, got %s", resource.ClusterType3, cl.GetTypeUrl())
+	}
+}
+
+func TestDiscoveryResubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := cache.New()
+	c.Insert(newCluster("a"))
+	c.Insert(newCluster("b"))
+	s := &server{cache: c, ctx: ctx}
+
+	m := &mockStream{sent: make(chan *xdspb2.DiscoveryResponse, 1)}
+	reqCh := make(chan *xdspb2.DiscoveryRequest)
+	go s.discoveryProcess(m, reqCh, resource.EndpointType)
+
+	reqCh <- &xdspb2.DiscoveryRequest{ResourceNames: []string{"a", "b"}}
+	resp := expectResponse(t, m)
+	if len(resp.Resources) != 2 {
+		t.Fatalf("Expected %d resources, got %d", 2, len(resp.Resources))
+	}
+
+	// unsubscribe from "a", nothing changed for "b"
+	reqCh <- &xdspb2.DiscoveryRequest{VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce, ResourceNames: []string{"b"}}
+	expectNoResponse(t, m)
+
+	// subscribing to "a" again must send it again
+	reqCh <- &xdspb2.DiscoveryRequest{VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce, ResourceNames: []string{"a", "b"}}
+	resp = expectResponse(t, m)
+	if len(resp.Resources) != 1 {
+		t.Fatalf("Expected %d resource, got %d", 1, len(resp.Resources))
+	}
+	cla := &xdspb2.ClusterLoadAssignment{}
+	if err := ptypes.UnmarshalAny(resp.Resources[0], cla); err != nil {
+		t.Fatal(err)
+	}
+	if cla.ClusterName != "a" {
+		t.Errorf("Expected cluster %q, got %q", "a", cla.ClusterName)
+	}
+}
+
+type mockDeltaStream struct {
+	grpc.ServerStream
+	sent chan *xdspb2.DeltaDiscoveryResponse
+}
+
+func (m *mockDeltaStream) Send(resp *xdspb2.DeltaDiscoveryResponse) error { m.sent <- resp; return nil }
+func (m *mockDeltaStream) Recv() (*xdspb2.DeltaDiscoveryRequest, error)   { return nil, io.EOF }
+
+func TestDelta(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := cache.New()
+	c.Insert(newCluster("a"))
+	c.Insert(newCluster("b"))
+	s := &server{cache: c, ctx: ctx}
+
+	m := &mockDeltaStream{sent: make(chan *xdspb2.DeltaDiscoveryResponse, 1)}
+	reqCh := make(chan *xdspb2.DeltaDiscoveryRequest)
+	go s.deltaProcess(m, reqCh, resource.EndpointType)
+
+	reqCh <- &xdspb2.DeltaDiscoveryRequest{ResourceNamesSubscribe: []string{"a"}, InitialResourceVersions: map[string]string{"a": deltaVersion(1)}}
+	select {
+	case resp := <-m.sent:
+		t.Fatalf("Expected no response, got %v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	reqCh <- &xdspb2.DeltaDiscoveryRequest{ResourceNamesSubscribe: []string{"b"}}
+	resp := <-m.sent
+	if len(resp.Resources) != 1 || resp.Resources[0].Name != "b" || resp.Resources[0].Version != deltaVersion(2) {
+		t.Fatalf("Expected resource %q with version %s, got %v", "b", deltaVersion(2), resp.Resources)
+	}
+	reqCh <- &xdspb2.DeltaDiscoveryRequest{ResponseNonce: resp.Nonce}
+
+	c.Delete("a")
+	resp = <-m.sent
+	if len(resp.Resources) != 0 || len(resp.RemovedResources) != 1 || resp.RemovedResources[0] != "a" {
+		t.Fatalf("Expected %q to be removed, got %v", "a", resp)
+	}
+}
+
+func TestDeltaRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := cache.New()
+	c.Insert(newCluster("a"))
+	s := &server{cache: c, ctx: ctx}
+
+	m := &mockDeltaStream{sent: make(chan *xdspb2.DeltaDiscoveryResponse, 1)}
+	reqCh := make(chan *xdspb2.DeltaDiscoveryRequest)
+	go s.deltaProcess(m, reqCh, resource.EndpointType)
+
+	// the client got version 1 of "a" from an xds that has since restarted.
+	reqCh <- &xdspb2.DeltaDiscoveryRequest{ResourceNamesSubscribe: []string{"a"}, InitialResourceVersions: map[string]string{"a": "0.1"}}
+	select {
+	case resp := <-m.sent:
+		if len(resp.Resources) != 1 || resp.Resources[0].Name != "a" || resp.Resources[0].Version != deltaVersion(1) {
+			t.Fatalf("Expected resource %q with version %s, got %v", "a", deltaVersion(1), resp.Resources)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected resource %q, got none", "a")
+	}
+}
+
+func TestDeltaStaleNonce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := cache.New()
+	c.Insert(newCluster("a"))
+	c.Insert(newCluster("b"))
+	c.Insert(newCluster("c"))
+	s := &server{cache: c, ctx: ctx}
+
+	m := &mockDeltaStream{sent: make(chan *xdspb2.DeltaDiscoveryResponse, 1)}
+	reqCh := make(chan *xdspb2.DeltaDiscoveryRequest)
+	go s.deltaProcess(m, reqCh, resource.EndpointType)
+
+	reqCh <- &xdspb2.DeltaDiscoveryRequest{ResourceNamesSubscribe: []string{"a"}}
+	first := <-m.sent
+	reqCh <- &xdspb2.DeltaDiscoveryRequest{ResourceNamesSubscribe: []string{"b"}}
+	<-m.sent
+
+	// a subscription change in a reply to the first response must still be applied
+	reqCh <- &xdspb2.DeltaDiscoveryRequest{ResponseNonce: first.Nonce, ResourceNamesSubscribe: []string{"c"}}
+	select {
+	case resp := <-m.sent:
+		if len(resp.Resources) != 1 || resp.Resources[0].Name != "c" {
+			t.Fatalf("Expected resource %q, got %v", "c", resp.Resources)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected resource %q, got none", "c")
+	}
+}
+
+type mockHealthStream struct {
+	grpc.ServerStream
+	sent chan *healthpb2.HealthCheckSpecifier
+}
+
+func (m *mockHealthStream) Send(resp *healthpb2.HealthCheckSpecifier) error {
+	m.sent <- resp
+	return nil
+}
+func (m *mockHealthStream) Recv() (*healthpb2.HealthCheckRequestOrEndpointHealthResponse, error) {
+	return nil, io.EOF
+}
+func (m *mockHealthStream) Context() context.Context { return context.Background() }
+
+func TestHealthDiscovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl, ep := newEndpointCluster("a")
+	cl.HealthChecks = []*corepb2.HealthCheck{{HealthChecker: &corepb2.HealthCheck_TcpHealthCheck_{TcpHealthCheck: &corepb2.HealthCheck_TcpHealthCheck{}}}}
+	c := cache.New()
+	c.Insert(cl)
+	s := &server{cache: c, ctx: ctx}
+
+	m := &mockHealthStream{sent: make(chan *healthpb2.HealthCheckSpecifier, 1)}
+	reqCh := make(chan *healthpb2.HealthCheckRequestOrEndpointHealthResponse)
+	go s.healthProcess(m, reqCh)
+
+	reqCh <- &healthpb2.HealthCheckRequestOrEndpointHealthResponse{
+		RequestType: &healthpb2.HealthCheckRequestOrEndpointHealthResponse_HealthCheckRequest{
+			HealthCheckRequest: &healthpb2.HealthCheckRequest{Node: &corepb2.Node{Id: "envoy"}},
+		},
+	}
+	hs := <-m.sent
+	if len(hs.ClusterHealthChecks) != 1 || hs.ClusterHealthChecks[0].ClusterName != "a" {
+		t.Fatalf("Expected health checks for cluster %q, got %v", "a", hs.ClusterHealthChecks)
+	}
+	if x := len(hs.ClusterHealthChecks[0].LocalityEndpoints[0].Endpoints); x != 1 {
+		t.Fatalf("Expected %d endpoint to check, got %d", 1, x)
+	}
+
+	reqCh <- &healthpb2.HealthCheckRequestOrEndpointHealthResponse{
+		RequestType: &healthpb2.HealthCheckRequestOrEndpointHealthResponse_EndpointHealthResponse{
+			EndpointHealthResponse: &healthpb2.EndpointHealthResponse{
+				EndpointsHealth: []*healthpb2.EndpointHealth{{Endpoint: ep, HealthStatus: corepb2.HealthStatus_UNHEALTHY}},
+			},
+		},
+	}
+	// health changes don't change the health checks, so nothing should be sent
+	select {
+	case hs := <-m.sent:
+		t.Fatalf("Expected no health checks, got %v", hs)
+	case <-time.After(100 * time.Millisecond):
+	}
+	a, _ := c.Retrieve("a")
+	if x := a.LoadAssignment.Endpoints[0].LbEndpoints[0].HealthStatus; x != corepb2.HealthStatus_UNHEALTHY {
+		t.Errorf("Expected endpoint to be %s, got %s", corepb2.HealthStatus_UNHEALTHY, x)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	cl, _ := newEndpointCluster("a")
+	c := cache.New()
+	c.Insert(cl)
+	policy := &Policy{
+		Tokens: map[string]string{"t1": "alice", "t2": "bob"},
+		Groups: map[string][]string{"oncall": {"alice"}},
+		Rules:  []Rule{{Identities: []string{"oncall"}, Clusters: []string{"*"}, Actions: []string{ActionHealth}}},
+	}
+	s := &server{cache: c, ctx: context.TODO(), policy: policy}
+
+	drain := &adminpb.SetEndpointHealthRequest{Cluster: "a", Health: adminpb.HealthStatus_DRAINING}
+	tests := []struct {
+		token string
+		code  codes.Code
+	}{
+		{"", codes.PermissionDenied},
+		{"t2", codes.PermissionDenied},
+		{"t1", codes.OK},
+	}
+	for i, tc := range tests {
+		ctx := context.TODO()
+		if tc.token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tc.token))
+		}
+		_, err := s.Admin().SetEndpointHealth(ctx, drain)
+		if x := grpcstatus.Code(err); x != tc.code {
+			t.Errorf("Test %d, expected code %s, got %s", i, tc.code, x)
+		}
+	}
+	a, _ := c.Retrieve("a")
+	if x := a.LoadAssignment.Endpoints[0].LbEndpoints[0].HealthStatus; x != corepb2.HealthStatus_DRAINING {
+		t.Errorf("Expected endpoint to be %s, got %s", corepb2.HealthStatus_DRAINING, x)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	cl, _ := newEndpointCluster("a")
+	c := cache.New()
+	c.Insert(cl)
+	policy := &Policy{
+		Tokens: map[string]string{"t1": "alice"},
+		Rules:  []Rule{{Identities: []string{"alice"}, Clusters: []string{"*"}, Actions: []string{ActionHealth}}},
+	}
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &server{cache: c, ctx: context.TODO(), policy: policy, audit: l}
+
+	// the second drain changes nothing and is not recorded.
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("authorization", "Bearer t1"))
+	drain := &adminpb.SetEndpointHealthRequest{Cluster: "a", Health: adminpb.HealthStatus_DRAINING}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Admin().SetEndpointHealth(ctx, drain); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := l.Query(audit.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected %d audit entry, got %d", 1, len(entries))
+	}
+	e := entries[0]
+	if e.Identity != "alice" || e.Operation != audit.SetHealth || e.Endpoint != "127.0.0.1:80" || e.Old != "UNKNOWN" || e.New != "DRAINING" {
+		t.Errorf("Expected alice draining %s, got %+v", "127.0.0.1:80", e)
+	}
+}
+
+type mockLoadStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *loadpb2.LoadStatsResponse
+}
+
+func (m *mockLoadStream) Context() context.Context                   { return m.ctx }
+func (m *mockLoadStream) Send(resp *loadpb2.LoadStatsResponse) error { m.sent <- resp; return nil }
+func (m *mockLoadStream) Recv() (*loadpb2.LoadStatsRequest, error)   { return nil, io.EOF }
+
+func TestLoadAuthorization(t *testing.T) {
+	c := cache.New()
+	c.Insert(newCluster("a"))
+	policy := &Policy{
+		Tokens: map[string]string{"t1": "envoy"},
+		Rules:  []Rule{{Identities: []string{"envoy"}, Clusters: []string{"*"}, Actions: []string{ActionLoad}}},
+	}
+	s := &server{cache: c, ctx: context.TODO(), policy: policy}
+
+	report := &loadpb2.LoadStatsRequest{ClusterStats: []*edspb2.ClusterStats{{
+		ClusterName:           "a",
+		UpstreamLocalityStats: []*edspb2.UpstreamLocalityStats{{TotalSuccessfulRequests: 10}},
+	}}}
+	tests := []struct {
+		token string
+		code  codes.Code
+	}{
+		{"", codes.PermissionDenied},
+		{"t1", codes.OK},
+	}
+	for i, tc := range tests {
+		ctx := context.TODO()
+		if tc.token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tc.token))
+		}
+		m := &mockLoadStream{ctx: ctx, sent: make(chan *loadpb2.LoadStatsResponse, 1)}
+		reqCh := make(chan *loadpb2.LoadStatsRequest, 1)
+		reqCh <- report
+		close(reqCh)
+		err := s.loadProcess(m, reqCh)
+		if x := grpcstatus.Code(err); x != tc.code {
+			t.Errorf("Test %d, expected code %s, got %s", i, tc.code, x)
+		}
+	}
+}
+
+// fakeCache only implements Fetch, calling anything else panics.
+type fakeCache struct {
+	cache.Cache
+	resp *xdspb2.DiscoveryResponse
+}
+
+func (f *fakeCache) Fetch(req *xdspb2.DiscoveryRequest) (*xdspb2.DiscoveryResponse, error) {
+	return f.resp, nil
+}
+
+func TestFakeCache(t *testing.T) {
+	f := &fakeCache{resp: &xdspb2.DiscoveryResponse{VersionInfo: "42"}}
+	s := NewServer(context.TODO(), f, nil, nil)
+
+	resp, err := s.FetchClusters(context.TODO(), &xdspb2.DiscoveryRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetVersionInfo() != "42" {
+		t.Errorf("Expected version %s, got %s", "42", resp.GetVersionInfo())
+	}
+}
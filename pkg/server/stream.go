@@ -0,0 +1,88 @@
+package server
+
+import (
+	"sort"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/miekg/xds/pkg/cache"
+	"github.com/miekg/xds/pkg/resource"
+)
+
+// typeState tracks, for a single type URL on a single stream, what we have sent to the client and how the
+// client responded to it.
+type typeState struct {
+	names   []string // resource names the client is subscribed to, sorted
+	nonce   string   // nonce of the last response we sent
+	version string   // version of the last response we sent
+	acked   string   // last version the client ACKed
+	nacked  string   // last version the client NACKed, we will not push this version again
+	node    string   // ID of the node that subscribed, for metrics
+
+	sent map[string]uint64 // resource name -> version of the resources we've sent
+}
+
+// namesChanged returns true if the resource names in req differ from the ones we have on record.
+func (t *typeState) namesChanged(req *xdspb2.DiscoveryRequest) bool {
+	names := append([]string{}, req.ResourceNames...)
+	sort.Strings(names)
+	if len(names) != len(t.names) {
+		return true
+	}
+	for i := range names {
+		if names[i] != t.names[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// setNames records the resource names in req. Resources the client is no longer subscribed to are forgotten, so they
+// are sent again when the client resubscribes.
+func (t *typeState) setNames(req *xdspb2.DiscoveryRequest) {
+	t.names = append([]string{}, req.ResourceNames...)
+	sort.Strings(t.names)
+	if len(t.names) == 0 {
+		return
+	}
+	for n := range t.sent {
+		if i := sort.SearchStrings(t.names, n); i == len(t.names) || t.names[i] != n {
+			delete(t.sent, n)
+		}
+	}
+}
+
+// changed returns the resources whose version differs from the one we've sent.
+func (t *typeState) changed(resources []cache.Resource) []cache.Resource {
+	rs := []cache.Resource{}
+	for _, r := range resources {
+		if v, ok := t.sent[r.Name]; ok && v == r.Version {
+			continue
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// setSent records the versions of the resources we've sent.
+func (t *typeState) setSent(resources []cache.Resource) {
+	if t.sent == nil {
+		t.sent = map[string]uint64{}
+	}
+	for _, r := range resources {
+		t.sent[r.Name] = r.Version
+	}
+}
+
+// partial returns true if responses for typeURL may carry a subset of the resources. This is allowed for EDS and
+// RDS, for CDS and LDS each response must have the complete set.
+func partial(typeURL string) bool {
+	typeURL = resource.V2(typeURL)
+	return typeURL == resource.EndpointType || typeURL == resource.RouteConfigType
+}
+
+// pushOrder is the order in which we push the types when the cache changes. ADS relies on clusters being sent
+// before their endpoints, and listeners before their routes, so Envoy doesn't drop traffic while it updates.
+var pushOrder = []string{
+	resource.ClusterType, resource.EndpointType, resource.ListenerType, resource.RouteConfigType,
+	resource.ClusterType3, resource.EndpointType3, resource.ListenerType3, resource.RouteConfigType3,
+}
@@ -0,0 +1,300 @@
+// Package store implements a key/value store that is kept in a single file. Every change is appended to the
+// file as a record, when the file is opened the records are replayed and the file is compacted, leaving a
+// single put record for each key. While in use the file is compacted again when it grows past CompactSize and
+// to more than twice its compacted size.
+//
+// The file starts with a header holding the magic "XDSSTORE" and the version of the format as an uint32 in
+// big endian. Each record that follows is:
+//
+//	op (1 byte) | key length (uvarint) | key | value length (uvarint) | value | CRC32 (IEEE) of all before (4 bytes)
+//
+// A delete record has an empty value. The last record is discarded if it is cut short or its checksum doesn't
+// match, i.e. a write that was interrupted. Anywhere else that means the file is corrupt and opening it fails.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Version is the version of the on-disk format written by this package.
+const Version = 1
+
+const magic = "XDSSTORE"
+
+const (
+	opPut    = 1
+	opDelete = 2
+)
+
+// CompactSize is the size the file may grow to before it is compacted while in use, see File.grown.
+const CompactSize = 4 << 20
+
+// errShort is returned when a record is cut short.
+var errShort = errors.New("short record")
+
+// errChecksum is returned when the checksum of a record doesn't match.
+var errChecksum = errors.New("checksum mismatch")
+
+// File is a key/value store backed by a journal file.
+type File struct {
+	mu          sync.Mutex
+	path        string
+	f           *os.File
+	data        map[string][]byte
+	size        int64 // size of the file.
+	compacted   int64 // size of the file after the last compaction.
+	compactSize int64 // see CompactSize.
+}
+
+// Open opens the store in path, creating it if it doesn't exist. The existing journal is replayed and
+// compacted.
+func Open(path string) (*File, error) {
+	s := &File{path: path, data: map[string][]byte{}, compactSize: CompactSize}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(buf) > 0 {
+		if err := s.replay(buf); err != nil {
+			return nil, fmt.Errorf("store %q: %s", path, err)
+		}
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the value of key.
+func (s *File) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Keys returns all keys in alphabetical order.
+func (s *File) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Put sets key to value and writes this to disk before returning.
+func (s *File) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(opPut, key, value); err != nil {
+		return err
+	}
+	s.data[key] = value
+	s.grown()
+	return nil
+}
+
+// Delete deletes key and writes this to disk before returning.
+func (s *File) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	if err := s.append(opDelete, key, nil); err != nil {
+		return err
+	}
+	delete(s.data, key)
+	s.grown()
+	return nil
+}
+
+// Close closes the store.
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// append appends a record to the journal and syncs it. If that fails the journal is truncated to what it was
+// before, so a partial record doesn't end up in the middle of the file.
+func (s *File) append(op byte, key string, value []byte) error {
+	n, err := s.f.Write(record(op, key, value))
+	if err == nil {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		s.f.Truncate(s.size)
+		return err
+	}
+	s.size += int64(n)
+	return nil
+}
+
+// grown compacts the journal if it grew past CompactSize and to more than twice its size after the last
+// compaction. The changes are already on disk, so if this fails we just try again on the next change.
+func (s *File) grown() {
+	if s.size > s.compactSize && s.size > 2*s.compacted {
+		s.compact()
+	}
+}
+
+// replay reads the journal in buf into s.data.
+func (s *File) replay(buf []byte) error {
+	if len(buf) < len(magic)+4 || string(buf[:len(magic)]) != magic {
+		return fmt.Errorf("not a store file")
+	}
+	if v := binary.BigEndian.Uint32(buf[len(magic):]); v != Version {
+		return fmt.Errorf("unsupported version %d, want %d", v, Version)
+	}
+	r := bufio.NewReader(bytes.NewReader(buf[len(magic)+4:]))
+	for i := 0; ; i++ {
+		op, key, value, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err == errShort || err == errChecksum {
+			if _, perr := r.Peek(1); perr == io.EOF { // a torn write of the last record
+				return nil
+			}
+			return fmt.Errorf("record %d: %s", i, err)
+		}
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opPut:
+			s.data[key] = value
+		case opDelete:
+			delete(s.data, key)
+		default:
+			return fmt.Errorf("unknown operation %d", op)
+		}
+	}
+}
+
+// compact writes s.data as a new journal, atomically replacing the old one, and opens it for appending. If
+// this fails the old journal is still in use.
+func (s *File) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(magic)
+	binary.Write(w, binary.BigEndian, uint32(Version))
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	size := int64(len(magic) + 4)
+	for _, k := range keys {
+		n, _ := w.Write(record(opPut, k, s.data[k]))
+		size += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tmp.Name(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		f.Close()
+		return err
+	}
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f = f
+	s.size, s.compacted = size, size
+	return nil
+}
+
+func record(op byte, key string, value []byte) []byte {
+	buf := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(key)+len(value)+4)
+	buf = append(buf, op)
+	buf = appendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = appendUvarint(buf, uint64(len(value)))
+	buf = append(buf, value...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(buf))
+	return append(buf, crc...)
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], x)
+	return append(buf, b[:n]...)
+}
+
+// readRecord reads a record from r. It returns io.EOF if there are no more records, errShort if the record is
+// incomplete and errChecksum if its checksum doesn't match.
+func readRecord(r *bufio.Reader) (byte, string, []byte, error) {
+	crc := crc32.NewIEEE()
+	op, err := r.ReadByte()
+	if err != nil {
+		return 0, "", nil, io.EOF
+	}
+	crc.Write([]byte{op})
+	key, err := readBytes(r, crc)
+	if err != nil {
+		return 0, "", nil, errShort
+	}
+	value, err := readBytes(r, crc)
+	if err != nil {
+		return 0, "", nil, errShort
+	}
+	sum := make([]byte, 4)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return 0, "", nil, errShort
+	}
+	if binary.BigEndian.Uint32(sum) != crc.Sum32() {
+		return 0, "", nil, errChecksum
+	}
+	return op, string(key), value, nil
+}
+
+// readBytes reads a length prefixed byte slice, the bytes read are added to crc.
+func readBytes(r *bufio.Reader, crc io.Writer) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > 1<<30 {
+		return nil, errShort
+	}
+	crc.Write(appendUvarint(nil, l))
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	crc.Write(b)
+	return b, nil
+}
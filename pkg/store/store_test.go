@@ -0,0 +1,158 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("a", []byte("1"))
+	s.Put("b", []byte("2"))
+	s.Put("a", []byte("3"))
+	s.Delete("b")
+	s.Close()
+
+	// simulate a write that got interrupted
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write(record(opPut, "c", []byte("4"))[:5])
+	f.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if keys := s.Keys(); len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("Expected keys %v, got %v", []string{"a"}, keys)
+	}
+	if v, _ := s.Get("a"); string(v) != "3" {
+		t.Errorf("Expected value %q for %q, got %q", "3", "a", v)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("a", []byte("1"))
+	s.Put("b", []byte("2"))
+	s.Close()
+
+	// flip a bit in the value of the first record, the one of "b" follows it.
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[len(magic)+4+4] ^= 1
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Errorf("Expected error for corrupt record, got none")
+	}
+
+	// the same for the last record is a torn write, and dropped.
+	buf[len(magic)+4+4] ^= 1
+	buf[len(buf)-1] ^= 1
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if keys := s.Keys(); len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("Expected keys %v, got %v", []string{"a"}, keys)
+	}
+
+	// an impossible key length in the first record isn't a torn write either.
+	buf[len(buf)-1] ^= 1
+	copy(buf[len(magic)+4+1:], []byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Errorf("Expected error for corrupt length, got none")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	if err := ioutil.WriteFile(path, []byte(magic+"\x00\x00\x00\x02"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Errorf("Expected error for unsupported version, got none")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.compactSize = 100
+	for i := 0; i < 100; i++ {
+		if err := s.Put("a", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Put("b", []byte("b"))
+	s.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > 200 {
+		t.Errorf("Expected size smaller than %d, got %d", 200, fi.Size())
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, _ := s.Get("a"); string(v) != "99" {
+		t.Errorf("Expected value %q for %q, got %q", "99", "a", v)
+	}
+	if v, _ := s.Get("b"); string(v) != "b" {
+		t.Errorf("Expected value %q for %q, got %q", "b", "b", v)
+	}
+}
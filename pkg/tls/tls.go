@@ -0,0 +1,172 @@
+// Package tls creates TLS configurations from certificate, key and CA files. For servers the files are reloaded
+// when they change on disk, so certificates can be rotated without a restart.
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/xds/pkg/log"
+)
+
+// checkInterval is the minimum time between checking the files for changes.
+const checkInterval = 1 * time.Second
+
+// Reloader holds a certificate and an optional CA pool loaded from files. The files are checked for changes
+// during TLS handshakes and reloaded when they have changed.
+type Reloader struct {
+	cert, key, ca string
+
+	mu      sync.RWMutex
+	tlsCert *tls.Certificate
+	pool    *x509.CertPool
+	mod     time.Time // newest modification time of the files.
+	checked time.Time // last time we checked the files.
+}
+
+// NewReloader loads the certificate and key, and the CA bundle if ca is not empty.
+func NewReloader(cert, key, ca string) (*Reloader, error) {
+	r := &Reloader{cert: cert, key: key, ca: ca}
+	mod, err := r.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(mod); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ServerConfig returns a TLS configuration for a server. If a CA was given, clients must present a certificate
+// signed by it.
+func (r *Reloader) ServerConfig() *tls.Config { return r.serverConfig("h2") }
+
+// HTTPServerConfig is like ServerConfig, but for an HTTP server that also speaks HTTP/1.1.
+func (r *Reloader) HTTPServerConfig() *tls.Config { return r.serverConfig("h2", "http/1.1") }
+
+func (r *Reloader) serverConfig(protos ...string) *tls.Config {
+	// The configuration from GetConfigForClient replaces this one, so it must carry everything set here,
+	// including the protocols for ALPN, which gRPC and net/http otherwise add to the outer configuration only.
+	base := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: protos}
+	cfg := base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.reload()
+
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		cfg := base.Clone()
+		cfg.Certificates = []tls.Certificate{*r.tlsCert}
+		if r.pool != nil {
+			cfg.ClientCAs = r.pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return cfg, nil
+	}
+	return cfg
+}
+
+// reload reloads the files if they have changed since we last loaded them. Errors are logged and the current
+// certificate is kept.
+func (r *Reloader) reload() {
+	r.mu.Lock()
+	if time.Since(r.checked) < checkInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.checked = time.Now()
+	last := r.mod
+	r.mu.Unlock()
+
+	mod, err := r.modTime()
+	if err != nil {
+		log.Warningf("Failed to check TLS files: %s", err)
+		return
+	}
+	if !mod.After(last) {
+		return
+	}
+	if err := r.load(mod); err != nil {
+		log.Warningf("Failed to reload TLS files: %s", err)
+		return
+	}
+	log.Infof("Reloaded TLS certificate %q", r.cert)
+}
+
+// load loads the files, mod is recorded as the modification time of the loaded files.
+func (r *Reloader) load(mod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.cert, r.key)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.ca != "" {
+		if pool, err = loadPool(r.ca); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tlsCert = &cert
+	r.pool = pool
+	r.mod = mod
+	r.checked = time.Now()
+	return nil
+}
+
+// modTime returns the newest modification time of the files.
+func (r *Reloader) modTime() (time.Time, error) {
+	var mod time.Time
+	for _, f := range []string{r.cert, r.key, r.ca} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	return mod, nil
+}
+
+// NewClientConfig returns a TLS configuration for a client. The certificate and key are optional and only
+// needed when the server requires client certificates. If ca is empty, the system's CAs are used to verify
+// the server.
+func NewClientConfig(cert, key, ca string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cert != "" || key != "" {
+		c, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{c}
+	}
+	if ca != "" {
+		pool, err := loadPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func loadPool(ca string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %q", ca)
+	}
+	return pool, nil
+}
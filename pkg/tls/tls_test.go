@@ -0,0 +1,157 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCert creates a certificate with serial signed by parent (or self-signed if parent is nil) and writes it and
+// its key to dir/name.crt and dir/name.key.
+func newCert(t *testing.T, dir, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := newCert(t, dir, "ca", 1, nil, nil)
+	newCert(t, dir, "server", 2, ca, caKey)
+	newCert(t, dir, "client", 3, ca, caKey)
+
+	r, err := NewReloader(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", r.ServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	serial := func(cfg *tls.Config) (int64, error) {
+		cfg.ServerName = "localhost"
+		conn, err := tls.Dial("tcp", l.Addr().String(), cfg)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	cfg, err := NewClientConfig(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := serial(cfg); err != nil || s != 2 {
+		t.Fatalf("Expected serial %d, got %d: %v", 2, s, err)
+	}
+
+	// gRPC needs "h2" to be negotiated.
+	h2 := cfg.Clone()
+	h2.ServerName = "localhost"
+	h2.NextProtos = []string{"h2"}
+	conn, err := tls.Dial("tcp", l.Addr().String(), h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "h2" {
+		t.Errorf("Expected protocol %q to be negotiated, got %q", "h2", p)
+	}
+	conn.Close()
+
+	// the HTTP endpoints also get HTTP/1.1 clients.
+	hl, err := tls.Listen("tcp", "127.0.0.1:0", r.HTTPServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hl.Close()
+	go func() {
+		conn, err := hl.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+	h1 := cfg.Clone()
+	h1.ServerName = "localhost"
+	h1.NextProtos = []string{"http/1.1"}
+	conn, err = tls.Dial("tcp", hl.Addr().String(), h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "http/1.1" {
+		t.Errorf("Expected protocol %q to be negotiated, got %q", "http/1.1", p)
+	}
+	conn.Close()
+
+	// rotate the server certificate, and make sure the change is noticed.
+	newCert(t, dir, "server", 4, ca, caKey)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.crt"), future, future)
+	r.mu.Lock()
+	r.checked = time.Time{}
+	r.mu.Unlock()
+
+	if s, err := serial(cfg); err != nil || s != 4 {
+		t.Fatalf("Expected serial %d, got %d: %v", 4, s, err)
+	}
+}
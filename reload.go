@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/miekg/xds/pkg/audit"
+	"github.com/miekg/xds/pkg/cache"
+	"github.com/miekg/xds/pkg/log"
+	"github.com/miekg/xds/pkg/metrics"
+)
+
+// confLog logs loading (and writing back) the configuration.
+var confLog = log.New(log.Config)
+
+// auditLog records the changes made to the cache, if not nil.
+var auditLog *audit.Log
+
+// configCaller is who made the change, in the audit log, for changes read from the configuration directory.
+var configCaller = audit.Caller{Identity: "config"}
+
+var reloads = metrics.NewCounter("config", "reloads_total", "Configuration reloads per result (success or failure).", "result")
+
+// reloadConfig reparses all clusters and views in path. The changes are only applied if every file is valid,
+// and then all at once: clusters are added, updated and removed, and the views replaced, in a single cache
+// update. For each file what changed, or why it was rejected, is logged. If quiet is true, as for the periodic
+// reread, a reload that changed nothing isn't recorded as the last one, so it doesn't hide what the last
+// reload that did something reported.
+func reloadConfig(config *cache.Cluster, path string, quiet bool) {
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	dir, err := ioutil.ReadDir(path)
+	if err != nil {
+		confLog.Warningf("Error reloading %q: %s", path, err)
+		reloads.Inc("failure")
+		setReload(false, []string{err.Error()})
+		return
+	}
+
+	var (
+		clusters = []*xdspb2.Cluster{}
+		names    = map[string]bool{}
+		views    = []*cache.View{}
+		rejected = []string{}
+	)
+	for _, f := range dir {
+		if f.IsDir() {
+			continue
+		}
+		if name, ok := clusterName(f.Name()); ok {
+			cl, err := parseCluster(path, name)
+			if err != nil {
+				rejected = append(rejected, fmt.Sprintf("%s: rejected: %s", f.Name(), err))
+				continue
+			}
+			clusters = append(clusters, cl)
+			names[name] = true
+			continue
+		}
+		if name, ok := viewName(f.Name()); ok {
+			v, err := parseView(path, name)
+			if err != nil {
+				rejected = append(rejected, fmt.Sprintf("%s: rejected: %s", f.Name(), err))
+				continue
+			}
+			views = append(views, v)
+		}
+	}
+	if len(rejected) > 0 {
+		for _, r := range rejected {
+			confLog.Warningf("Reloading %q: %s", path, r)
+		}
+		confLog.Warningf("Reloading %q failed, %d file(s) rejected, nothing changed", path, len(rejected))
+		reloads.Inc("failure")
+		setReload(false, rejected)
+		return
+	}
+
+	var (
+		insert = []*xdspb2.Cluster{}
+		remove = []string{}
+		report = []string{}
+	)
+	for name := range deleted {
+		if !names[name] {
+			delete(deleted, name) // the file is gone as well
+		}
+	}
+	for _, cl := range clusters {
+		current, _ := config.Retrieve(cl.GetName())
+		switch {
+		case current == nil && deleted[cl.GetName()] == cache.HashFromMetadata(cl):
+			continue // deleted at runtime, and the file didn't change since
+		case current == nil:
+			delete(deleted, cl.GetName())
+			report = append(report, fmt.Sprintf("cluster.%s.textpb: added cluster %q", cl.GetName(), cl.GetName()))
+		case cache.HashFromMetadata(current) != cache.HashFromMetadata(cl):
+			report = append(report, fmt.Sprintf("cluster.%s.textpb: updated cluster %q", cl.GetName(), cl.GetName()))
+		default:
+			continue
+		}
+		insert = append(insert, cl)
+	}
+	for _, name := range config.All() {
+		if names[name] {
+			continue
+		}
+		if cl, _ := config.Retrieve(name); cache.HashFromMetadata(cl) == "" {
+			continue // created with the admin API, not from a file
+		}
+		report = append(report, fmt.Sprintf("cluster.%s.textpb: removed, deleting cluster %q", name, name))
+		remove = append(remove, name)
+	}
+
+	changes := audit.Changes{}
+	config.Reload(insert, remove, views, changes.Observe)
+	auditLog.Record(configCaller, changes...)
+	reloads.Inc("success")
+	if !quiet || len(report) > 0 {
+		setReload(true, report)
+	}
+	for _, r := range report {
+		confLog.Infof("Reloading %q: %s", path, r)
+	}
+	confLog.Debugf("Reloaded %q: %d clusters and %d views, %d change(s), cache version %d", path, len(clusters), len(views), len(report), config.Version())
+}
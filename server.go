@@ -16,27 +16,42 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 
 	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	clustersvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	discoverypb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
 	healthpb2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	discoverypb3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointsvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	healthpb3 "github.com/envoyproxy/go-control-plane/envoy/service/health/v3"
+	listenersvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
 	loadpb2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
+	loadpb3 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v3"
+	routesvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	statuspb2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	statuspb3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"github.com/miekg/xds/pkg/adminpb"
 	"github.com/miekg/xds/pkg/log"
 	"github.com/miekg/xds/pkg/server"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const grpcMaxConcurrentStreams = 1000000
 
-// RunManagementServer starts an xDS server at the given port.
-func RunManagementServer(ctx context.Context, server server.Server, addr string) {
+// RunManagementServer starts an xDS server at the given port. If tlsConfig is not nil the server uses TLS.
+func RunManagementServer(ctx context.Context, server server.Server, addr string, tlsConfig *tls.Config) {
 	// gRPC golang library sets a very small upper bound for the number gRPC/h2
 	// streams over a single TCP connection. If a proxy multiplexes requests over
 	// a single connection to the management server, then it might lead to
 	// availability problems.
 	var grpcOptions []grpc.ServerOption
 	grpcOptions = append(grpcOptions, grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams))
+	if tlsConfig != nil {
+		grpcOptions = append(grpcOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
 	grpcServer := grpc.NewServer(grpcOptions...)
 
 	lis, err := net.Listen("tcp", addr)
@@ -44,15 +59,9 @@ func RunManagementServer(ctx context.Context, server server.Server, addr string)
 		log.Fatal(err)
 	}
 
-	// register services
-	xdspb2.RegisterEndpointDiscoveryServiceServer(grpcServer, server)
-	healthpb2.RegisterHealthDiscoveryServiceServer(grpcServer, server)
-	discoverypb2.RegisterAggregatedDiscoveryServiceServer(grpcServer, server)
-	xdspb2.RegisterClusterDiscoveryServiceServer(grpcServer, server)
-	xdspb2.RegisterListenerDiscoveryServiceServer(grpcServer, server)
-	loadpb2.RegisterLoadReportingServiceServer(grpcServer, server)
+	register(grpcServer, server)
 
-	log.Infof("Management server listening on %s", addr)
+	log.Infof("Management server listening on %s (TLS: %t)", addr, tlsConfig != nil)
 	go func() {
 		if err = grpcServer.Serve(lis); err != nil {
 			log.Error(err)
@@ -62,3 +71,28 @@ func RunManagementServer(ctx context.Context, server server.Server, addr string)
 
 	grpcServer.GracefulStop()
 }
+
+// register registers all v2 and v3 services, and the admin service, on grpcServer.
+func register(grpcServer *grpc.Server, server server.Server) {
+	xdspb2.RegisterEndpointDiscoveryServiceServer(grpcServer, server)
+	healthpb2.RegisterHealthDiscoveryServiceServer(grpcServer, server)
+	discoverypb2.RegisterAggregatedDiscoveryServiceServer(grpcServer, server)
+	xdspb2.RegisterClusterDiscoveryServiceServer(grpcServer, server)
+	xdspb2.RegisterListenerDiscoveryServiceServer(grpcServer, server)
+	xdspb2.RegisterRouteDiscoveryServiceServer(grpcServer, server)
+	loadpb2.RegisterLoadReportingServiceServer(grpcServer, server)
+	statuspb2.RegisterClientStatusDiscoveryServiceServer(grpcServer, server)
+
+	// and the v3 ones
+	server3 := server.V3()
+	endpointsvcpb3.RegisterEndpointDiscoveryServiceServer(grpcServer, server3)
+	healthpb3.RegisterHealthDiscoveryServiceServer(grpcServer, server3)
+	discoverypb3.RegisterAggregatedDiscoveryServiceServer(grpcServer, server3)
+	clustersvcpb3.RegisterClusterDiscoveryServiceServer(grpcServer, server3)
+	listenersvcpb3.RegisterListenerDiscoveryServiceServer(grpcServer, server3)
+	routesvcpb3.RegisterRouteDiscoveryServiceServer(grpcServer, server3)
+	loadpb3.RegisterLoadReportingServiceServer(grpcServer, server3)
+	statuspb3.RegisterClientStatusDiscoveryServiceServer(grpcServer, server3)
+
+	adminpb.RegisterAdminServiceServer(grpcServer, server.Admin())
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	xdspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	edspb2 "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	routepb3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	discoverypb3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	routesvcpb3 "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/miekg/xds/pkg/adminpb"
+	"github.com/miekg/xds/pkg/audit"
+	"github.com/miekg/xds/pkg/cache"
+	"github.com/miekg/xds/pkg/log"
+	"github.com/miekg/xds/pkg/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestServer(t *testing.T, c *cache.Cluster) (*grpc.ClientConn, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lis := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	register(grpcServer, server.NewServer(ctx, c, nil, nil))
+	go grpcServer.Serve(lis)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	cc, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc, func() {
+		cc.Close()
+		cancel()
+		grpcServer.Stop()
+	}
+}
+
+func TestRouteDiscovery(t *testing.T) {
+	c := cache.New()
+	c.Insert(&xdspb2.Cluster{Name: "a", LoadAssignment: &xdspb2.ClusterLoadAssignment{ClusterName: "a"}})
+	c.Insert(&xdspb2.Cluster{Name: "b", LoadAssignment: &xdspb2.ClusterLoadAssignment{ClusterName: "b"}})
+
+	cc, stop := newTestServer(t, c)
+	defer stop()
+
+	// v2 streaming RDS
+	rds := xdspb2.NewRouteDiscoveryServiceClient(cc)
+	stream, err := rds.StreamRoutes(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&xdspb2.DiscoveryRequest{ResourceNames: []string{"b"}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Resources) != 1 {
+		t.Fatalf("Expected %d resource, got %d", 1, len(resp.Resources))
+	}
+	routec := &xdspb2.RouteConfiguration{}
+	if err := ptypes.UnmarshalAny(resp.Resources[0], routec); err != nil {
+		t.Fatal(err)
+	}
+	if x := routec.GetVirtualHosts()[0].GetRoutes()[0].GetRoute().GetCluster(); x != "b" {
+		t.Errorf("Expected route to cluster %q, got %q", "b", x)
+	}
+
+	// v3 fetch RDS
+	rds3 := routesvcpb3.NewRouteDiscoveryServiceClient(cc)
+	resp3, err := rds3.FetchRoutes(context.TODO(), &discoverypb3.DiscoveryRequest{ResourceNames: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routec3 := &routepb3.RouteConfiguration{}
+	if err := ptypes.UnmarshalAny(resp3.Resources[0], routec3); err != nil {
+		t.Fatal(err)
+	}
+	if x := routec3.GetVirtualHosts()[0].GetRoutes()[0].GetRoute().GetCluster(); x != "a" {
+		t.Errorf("Expected route to cluster %q, got %q", "a", x)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	c := cache.New()
+	c.Insert(&xdspb2.Cluster{Name: "a", LoadAssignment: &xdspb2.ClusterLoadAssignment{
+		ClusterName: "a",
+		Endpoints: []*edspb2.LocalityLbEndpoints{{
+			Locality: &corepb2.Locality{Region: "us"},
+			LbEndpoints: []*edspb2.LbEndpoint{{HostIdentifier: &edspb2.LbEndpoint_Endpoint{Endpoint: &edspb2.Endpoint{
+				Address: &corepb2.Address{Address: &corepb2.Address_SocketAddress{
+					SocketAddress: &corepb2.SocketAddress{Address: "127.0.0.1", PortSpecifier: &corepb2.SocketAddress_PortValue{PortValue: 80}},
+				}},
+			}}}},
+		}},
+	}})
+
+	cc, stop := newTestServer(t, c)
+	defer stop()
+
+	admin := adminpb.NewAdminServiceClient(cc)
+	if _, err := admin.SetEndpointWeight(context.TODO(), &adminpb.SetEndpointWeightRequest{Cluster: "a", Endpoint: "127.0.0.1:80", Weight: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.SetLocalityWeight(context.TODO(), &adminpb.SetLocalityWeightRequest{Cluster: "a", Locality: "us", Weight: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.SetEndpointWeight(context.TODO(), &adminpb.SetEndpointWeightRequest{Cluster: "a", Endpoint: "127.0.0.2:80", Weight: 5}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected %s for unknown endpoint, got %v", codes.NotFound, err)
+	}
+
+	resp, err := admin.GetCluster(context.TODO(), &adminpb.GetClusterRequest{Cluster: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := &xdspb2.Cluster{}
+	if err := ptypes.UnmarshalAny(resp.GetCluster(), cl); err != nil {
+		t.Fatal(err)
+	}
+	lle := cl.GetLoadAssignment().GetEndpoints()[0]
+	if w := lle.GetLoadBalancingWeight().GetValue(); w != 3 {
+		t.Errorf("Expected locality weight %d, got %d", 3, w)
+	}
+	if w := lle.GetLbEndpoints()[0].GetLoadBalancingWeight().GetValue(); w != 5 {
+		t.Errorf("Expected endpoint weight %d, got %d", 5, w)
+	}
+}
+
+func TestUpsertCluster(t *testing.T) {
+	c := cache.New()
+	cc, stop := newTestServer(t, c)
+	defer stop()
+
+	admin := adminpb.NewAdminServiceClient(cc)
+	pb := &xdspb2.Cluster{Name: "b", ClusterDiscoveryType: &xdspb2.Cluster_Type{Type: xdspb2.Cluster_STATIC}}
+	any, _ := ptypes.MarshalAny(pb)
+	if _, err := admin.UpsertCluster(context.TODO(), &adminpb.UpsertClusterRequest{Cluster: any}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected %s for non EDS cluster, got %v", codes.InvalidArgument, err)
+	}
+
+	pb.ClusterDiscoveryType = &xdspb2.Cluster_Type{Type: xdspb2.Cluster_EDS}
+	pb.HealthChecks = []*corepb2.HealthCheck{{HealthChecker: &corepb2.HealthCheck_TcpHealthCheck_{TcpHealthCheck: &corepb2.HealthCheck_TcpHealthCheck{}}}}
+	any, _ = ptypes.MarshalAny(pb)
+	if _, err := admin.UpsertCluster(context.TODO(), &adminpb.UpsertClusterRequest{Cluster: any}); err != nil {
+		t.Fatal(err)
+	}
+	cl, _ := c.Retrieve("b")
+	if cl == nil {
+		t.Fatalf("Expected cluster %q to be created", "b")
+	}
+	if x := cl.GetLoadAssignment().GetClusterName(); x != "b" {
+		t.Errorf("Expected load assignment for cluster %q, got %q", "b", x)
+	}
+	if x := cl.GetHealthChecks()[0].GetInterval().GetSeconds(); x != 10 {
+		t.Errorf("Expected health check interval to default to %d, got %d", 10, x)
+	}
+
+	if _, err := admin.DeleteCluster(context.TODO(), &adminpb.DeleteClusterRequest{Cluster: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if cl, _ := c.Retrieve("b"); cl != nil {
+		t.Errorf("Expected cluster %q to be deleted", "b")
+	}
+}
+
+func TestWriteCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("cluster.helloworld.textpb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cluster.helloworld.textpb"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := parseClusters(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cache.New()
+	c.Insert(clusters[0])
+
+	if err := c.SetEndpointWeight("helloworld", "127.0.0.1:50051", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCluster(c, dir, "helloworld"); err != nil {
+		t.Fatal(err)
+	}
+
+	clusters, err = parseClusters(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, _ := c.Retrieve("helloworld")
+	if h1, h2 := cache.HashFromMetadata(cl), cache.HashFromMetadata(clusters[0]); h1 != h2 {
+		t.Errorf("Expected hash of the written file %q in the cache, got %q", h2, h1)
+	}
+	lb := clusters[0].GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints()[0]
+	if w := lb.GetLoadBalancingWeight().GetValue(); w != 7 {
+		t.Errorf("Expected weight %d to be written back, got %d", 7, w)
+	}
+
+	c.Delete("helloworld")
+	if err := writeCluster(c, dir, "helloworld"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cluster.helloworld.textpb")); !os.IsNotExist(err) {
+		t.Errorf("Expected file of deleted cluster to be removed, got %v", err)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Watching directories is only supported on Linux")
+	}
+	dir, err := ioutil.TempDir("", "xds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("cluster.helloworld.textpb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cache.New()
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		if err := watchConfig(c, dir, stop); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond) // let the watch start
+
+	// an editor's temporary file only causes a reread.
+	if err := ioutil.WriteFile(filepath.Join(dir, ".cluster.helloworld.textpb.swp"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cluster.helloworld.textpb"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { cl, _ := c.Retrieve("helloworld"); return cl != nil }) {
+		t.Fatalf("Expected cluster %q to be added", "helloworld")
+	}
+
+	if err := os.Remove(filepath.Join(dir, "cluster.helloworld.textpb")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { cl, _ := c.Retrieve("helloworld"); return cl == nil }) {
+		t.Fatalf("Expected cluster %q to be deleted", "helloworld")
+	}
+
+	// a Kubernetes ConfigMap: the file is a symlink into "..data", which is swapped out by a rename.
+	for i, d := range [][]byte{data, append(data, []byte("\n# changed\n")...)} {
+		version := fmt.Sprintf("..data_%d", i)
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, version, "cluster.helloworld.textpb"), d, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("..data_0", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..data/cluster.helloworld.textpb", filepath.Join(dir, "cluster.helloworld.textpb")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { cl, _ := c.Retrieve("helloworld"); return cl != nil }) {
+		t.Fatalf("Expected cluster %q to be added", "helloworld")
+	}
+	cl, _ := c.Retrieve("helloworld")
+	hash := cache.HashFromMetadata(cl)
+
+	if err := os.Symlink("..data_1", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { cl, _ := c.Retrieve("helloworld"); return cache.HashFromMetadata(cl) != hash }) {
+		t.Fatalf("Expected cluster %q to be updated", "helloworld")
+	}
+}
+
+// waitFor waits up to a second for f to return true.
+func waitFor(f func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("cluster.helloworld.textpb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cluster.helloworld.textpb"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cluster.bad.textpb"), []byte(`name: "bad" type: STATIC`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := cache.New()
+	reloadConfig(c, dir, false)
+	if names := c.All(); len(names) != 0 {
+		t.Fatalf("Expected no clusters when a file is rejected, got %v", names)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "cluster.bad.textpb")); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig(c, dir, false)
+	if names := c.All(); len(names) != 1 || names[0] != "helloworld" {
+		t.Fatalf("Expected cluster %q, got %v", "helloworld", names)
+	}
+
+	// the periodic reread changes nothing, and must keep the report of the last reload.
+	reloadConfig(c, dir, true)
+	lastReload.Lock()
+	report := lastReload.r.Report
+	lastReload.Unlock()
+	if len(report) != 1 {
+		t.Errorf("Expected the report of the last reload to be kept, got %v", report)
+	}
+}
+
+func TestReloadDeleted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("cluster.helloworld.textpb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "cluster.helloworld.textpb")
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { deleted = map[string]string{} }()
+
+	c := cache.New()
+	reloadConfig(c, dir, false)
+	c.Delete("helloworld")
+	trackDelete(c, dir, "helloworld")
+	reloadConfig(c, dir, false)
+	if names := c.All(); len(names) != 0 {
+		t.Fatalf("Expected deleted cluster to stay deleted, got %v", names)
+	}
+	reloadCluster(c, dir, "helloworld")
+	if names := c.All(); len(names) != 0 {
+		t.Fatalf("Expected deleted cluster to stay deleted, got %v", names)
+	}
+
+	if err := ioutil.WriteFile(file, append(data, []byte("\n# changed\n")...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig(c, dir, false)
+	if names := c.All(); len(names) != 1 || names[0] != "helloworld" {
+		t.Fatalf("Expected cluster %q after its file changed, got %v", "helloworld", names)
+	}
+}
+
+func TestHTTPAdmin(t *testing.T) {
+	c := cache.New()
+	c.Insert(&xdspb2.Cluster{Name: "a", LoadAssignment: &xdspb2.ClusterLoadAssignment{ClusterName: "a"}})
+	mux := http.NewServeMux()
+	registerAdmin(mux, c, server.NewServer(context.TODO(), c, nil, nil), nil)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/config_dump", nil))
+	dump := struct {
+		Version  string `json:"version"`
+		Clusters []struct {
+			Name string `json:"name"`
+		} `json:"clusters"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+	if dump.Version != "1" {
+		t.Errorf("Expected version %q, got %q", "1", dump.Version)
+	}
+	if len(dump.Clusters) != 1 || dump.Clusters[0].Name != "a" {
+		t.Errorf("Expected cluster %q in dump, got %s", "a", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/clients", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d for /clients, got %d", http.StatusOK, w.Code)
+	}
+
+	defer log.SetLevel(log.Cache, "")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/logging?component=cache&level=debug", nil))
+	levels := map[string]string{}
+	if err := json.Unmarshal(w.Body.Bytes(), &levels); err != nil {
+		t.Fatal(err)
+	}
+	if levels["cache"] != "debug" {
+		t.Errorf("Expected level %q for cache, got %s", "debug", w.Body)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/logging?component=cache&level=loud", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for unknown level, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	c := cache.New()
+	mux := http.NewServeMux()
+	registerAdmin(mux, c, server.NewServer(context.TODO(), c, nil, nil), nil)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/audit", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d without an audit log, got %d", http.StatusNotFound, w.Code)
+	}
+
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if auditLog, err = audit.Open(filepath.Join(dir, "audit.log")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { auditLog.Close(); auditLog = nil }()
+
+	// a cluster read from the configuration directory is recorded as made by "config".
+	data, err := ioutil.ReadFile("cluster.helloworld.textpb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`name: "helloworld"`), []byte(`name: "b"`), 1)
+	if err := ioutil.WriteFile(filepath.Join(dir, "cluster.b.textpb"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadCluster(c, dir, "b")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/audit?identity=config&cluster=b", nil))
+	entries := []audit.Entry{}
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Operation != audit.CreateCluster {
+		t.Errorf("Expected %s of cluster %q in the audit log, got %s", audit.CreateCluster, "b", w.Body)
+	}
+}
+
+func TestHTTPAdminPolicy(t *testing.T) {
+	c := cache.New()
+	policy := &server.Policy{
+		Tokens: map[string]string{"t1": "alice", "t2": "bob"},
+		Rules:  []server.Rule{{Identities: []string{"alice"}, Clusters: []string{"*"}, Actions: []string{server.ActionLogging}}},
+	}
+	mux := http.NewServeMux()
+	registerAdmin(mux, c, server.NewServer(context.TODO(), c, policy, nil), policy)
+
+	defer log.SetLevel(log.Cache, "")
+	tests := []struct {
+		token string
+		code  int
+	}{
+		{"", http.StatusForbidden},
+		{"t2", http.StatusForbidden},
+		{"t1", http.StatusOK},
+	}
+	for i, tc := range tests {
+		r := httptest.NewRequest("POST", "/logging?component=cache&level=debug", nil)
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("Test %d, expected status %d, got %d", i, tc.code, w.Code)
+		}
+	}
+
+	// reading the levels is always allowed.
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/logging", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/miekg/xds/pkg/audit"
+	"github.com/miekg/xds/pkg/cache"
+)
+
+// debounce is how long we wait for more changes in the configuration directory before acting on them.
+const debounce = 200 * time.Millisecond
+
+// watchConfig watches the configuration directory path and only reparses the files that changed. Changes are
+// debounced, so a burst of writes results in a single reload. A change to a file that doesn't match
+// "cluster.*.textpb" or "view.*.json" rereads all files, as it can be a directory the files are symlinked
+// from, like the "..data" one of a Kubernetes ConfigMap. An error is returned if the directory can't be
+// watched, or if watching it fails later on.
+func watchConfig(config *cache.Cluster, path string, stop <-chan bool) error {
+	events, errs, err := inotify(path, stop)
+	if err != nil {
+		return err
+	}
+
+	pending := map[string]struct{}{}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case name, ok := <-events:
+			if !ok {
+				select {
+				case err := <-errs:
+					return err
+				default: // stopped
+					return nil
+				}
+			}
+			_, isCluster := clusterName(name)
+			_, isView := viewName(name)
+			if !isCluster && !isView {
+				name = ""
+			}
+			pending[name] = struct{}{}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(debounce)
+		case <-timer.C:
+			if _, ok := pending[""]; ok {
+				confLog.Infof("Unknown changes in %q, rereading all files", path)
+				reloadConfig(config, path, false)
+				pending = map[string]struct{}{}
+				continue
+			}
+			views := false
+			for file := range pending {
+				if name, ok := clusterName(file); ok {
+					reloadCluster(config, path, name)
+					continue
+				}
+				views = true
+			}
+			if views {
+				reloadViews(config, path)
+			}
+			pending = map[string]struct{}{}
+		}
+	}
+}
+
+// reloadCluster reparses the file of cluster name in path and updates the cache if the file changed. If the
+// file is gone the cluster is deleted.
+func reloadCluster(config *cache.Cluster, path, name string) {
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	file := "cluster." + name + ".textpb"
+	c, err := parseCluster(path, name)
+	if os.IsNotExist(err) {
+		delete(deleted, name)
+		if cl, _ := config.Retrieve(name); cache.HashFromMetadata(cl) != "" {
+			confLog.With("cluster", name).Infof("Cluster %q removed from %q, deleting cluster", name, path)
+			changes := audit.Changes{}
+			config.Delete(name, changes.Observe)
+			auditLog.Record(configCaller, changes...)
+			reloads.Inc("success")
+			setReload(true, []string{fmt.Sprintf("%s: removed, deleting cluster %q", file, name)})
+		}
+		return
+	}
+	if err != nil {
+		confLog.With("cluster", name).Warningf("Error reparsing cluster %q: %s", name, err)
+		reloads.Inc("failure")
+		setReload(false, []string{fmt.Sprintf("%s: rejected: %s", file, err)})
+		return
+	}
+	cl, _ := config.Retrieve(name)
+	switch {
+	case cl == nil && deleted[name] == cache.HashFromMetadata(c):
+		return // deleted at runtime, and the file didn't change since
+	case cl == nil:
+		delete(deleted, name)
+		confLog.With("cluster", name).Infof("Found new cluster in %q, adding cluster %q", path, name)
+		setReload(true, []string{fmt.Sprintf("%s: added cluster %q", file, name)})
+	case cache.HashFromMetadata(cl) != cache.HashFromMetadata(c):
+		confLog.With("cluster", name).Infof("cluster in %q updated, re-inserting cluster %q", path, name)
+		setReload(true, []string{fmt.Sprintf("%s: updated cluster %q", file, name)})
+	default:
+		return
+	}
+	reloads.Inc("success")
+	changes := audit.Changes{}
+	config.Insert(c, changes.Observe)
+	auditLog.Record(configCaller, changes...)
+}
+
+// reloadViews reparses all views in path.
+func reloadViews(config *cache.Cluster, path string) {
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	views, err := parseViews(path)
+	if err != nil {
+		confLog.Warningf("Error reparsing views: %s", err)
+		reloads.Inc("failure")
+		setReload(false, []string{err.Error()})
+		return
+	}
+	reloads.Inc("success")
+	setReload(true, nil)
+	config.SetViews(views)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotify watches dir and sends the name of each file that is written, created or removed. The empty name is
+// sent when we can't tell what changed: the kernel dropped events, or dir itself was removed or renamed. The
+// returned channel is closed when stop is closed, when reading the events fails or when dir isn't watched
+// anymore; in the latter two cases the error is sent on the error channel first.
+func inotify(dir string, stop <-chan bool) (<-chan string, <-chan error, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Closed writes instead of every write, so we (mostly) don't see a partially written file. Creates are
+	// needed for symlinks, which are never written.
+	mask := uint32(unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE |
+		unix.IN_DELETE_SELF | unix.IN_MOVE_SELF)
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		unix.Close(fd)
+		return nil, nil, err
+	}
+	f := os.NewFile(uintptr(fd), "inotify") // non-blocking, so Close unblocks a pending Read.
+	go func() {
+		<-stop
+		f.Close()
+	}()
+
+	events := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				select {
+				case <-stop: // we closed f
+				default:
+					errs <- err
+				}
+				return
+			}
+			for off := 0; off+unix.SizeofInotifyEvent <= n; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+				off += unix.SizeofInotifyEvent + int(ev.Len)
+
+				if ev.Mask&(unix.IN_Q_OVERFLOW|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0 {
+					name = nil
+				}
+				select {
+				case events <- string(bytes.TrimRight(name, "\x00")):
+				case <-stop:
+					return
+				}
+				if ev.Mask&unix.IN_IGNORED != 0 { // the watch is gone, no more events will come
+					errs <- fmt.Errorf("%q is not watched anymore", dir)
+					return
+				}
+			}
+		}
+	}()
+	return events, errs, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// inotify is only implemented on Linux.
+func inotify(dir string, stop <-chan bool) (<-chan string, <-chan error, error) {
+	return nil, nil, errors.New("watching directories is only supported on Linux")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/xds/pkg/cache"
+)
+
+// confMu serializes reading and writing the configuration directory.
+var confMu sync.Mutex
+
+// writeBack writes the clusters that are changed at runtime back to their "cluster.NAME.textpb" file in path.
+// Clusters that are deleted get their file removed.
+func writeBack(config *cache.Cluster, path string, stop <-chan bool) {
+	watch, cancel := config.Watch()
+	defer cancel()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-watch:
+			for _, name := range config.Dirty() {
+				if err := writeCluster(config, path, name); err != nil {
+					confLog.With("cluster", name).Warningf("Error writing back cluster %q: %s", name, err)
+				}
+			}
+		}
+	}
+}
+
+// deleted holds the clusters that are deleted at runtime while their file is still there, because the changes
+// aren't written back: cluster name -> hash of that file. Rereading the configuration skips these files until
+// they change. Guarded by confMu.
+var deleted = map[string]string{}
+
+// trackDeletes records the clusters that are deleted at runtime in deleted, so rereading the configuration
+// doesn't bring them back. This takes the place of writeBack when the changes aren't written back.
+func trackDeletes(config *cache.Cluster, path string, stop <-chan bool) {
+	watch, cancel := config.Watch()
+	defer cancel()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-watch:
+			for _, name := range config.Dirty() {
+				trackDelete(config, path, name)
+			}
+		}
+	}
+}
+
+// trackDelete records the hash of the file of cluster name in deleted, if the cluster is deleted from config.
+func trackDelete(config *cache.Cluster, path, name string) {
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	if cl, _ := config.Retrieve(name); cl != nil {
+		delete(deleted, name) // (re)created with the admin API
+		return
+	}
+	cl, err := parseCluster(path, name)
+	if err != nil {
+		return // no (valid) file, nothing to bring the cluster back
+	}
+	deleted[name] = cache.HashFromMetadata(cl)
+	confLog.With("cluster", name).Infof("Cluster %q deleted, ignoring its file in %q until it changes", name, path)
+}
+
+// writeCluster writes cluster name from config to its file in path and sets the hash of the new file in the
+// cluster's metadata, so rereading the configuration leaves the cluster alone.
+func writeCluster(config *cache.Cluster, path, name string) error {
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	file := filepath.Join(path, "cluster."+name+".textpb")
+	cl, _ := config.Retrieve(name)
+	if cl == nil {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		confLog.With("cluster", name).Infof("Cluster %q deleted, removed %q", name, file)
+		return nil
+	}
+
+	data := []byte(proto.MarshalTextString(cache.Stripped(cl)))
+	if err := writeFile(file, data); err != nil {
+		return err
+	}
+	h := sha1.New()
+	h.Write(data)
+	config.SetHash(name, fmt.Sprintf("%x", h.Sum(nil)))
+	confLog.With("cluster", name).Debugf("Cluster %q written to %q", name, file)
+	return nil
+}
+
+// writeFile atomically replaces file with data, by writing to a temporary file in the same directory and
+// renaming that.
+func writeFile(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}